@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+const (
+	lookupCache = `SELECT media, mime_type, mime_subtype, mime_value, extension,
+	exif_datetime_original, exif_create_date, media_created, exif_json, media_info, hash, phash
+	FROM file_cache WHERE filepath = ? AND size = ? AND mtime_ns = ? AND inode = ?`
+
+	upsertCache = `INSERT OR REPLACE INTO file_cache
+	(filepath, size, mtime_ns, inode, media, mime_type, mime_subtype, mime_value, extension,
+	exif_datetime_original, exif_create_date, media_created, exif_json, media_info, hash, phash, last_seen_ns)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// rows below a root that weren't seen by the latest scan of that root
+	deleteStaleCache = `DELETE FROM file_cache WHERE last_seen_ns < ?
+	AND (filepath = ? OR substr(filepath, 1, length(?)) = ?)`
+)
+
+// Lookup returns the cached data of the file at path if it is unchanged; it
+// implements fastdu.Cache
+func (d *DBImpl) Lookup(path string, size int64, modtime time.Time, inode uint64) (fastdu.CacheEntry, bool) {
+	var (
+		e             fastdu.CacheEntry
+		dto, created  sql.NullTime
+		mediaCreated  sql.NullTime
+		exifJSON      sql.NullString
+		mediaInfo     sql.NullString
+		hash, phash   sql.NullString
+		mimeType, sub sql.NullString
+		value, ext    sql.NullString
+	)
+	err := d.lookup.QueryRow(path, size, modtime.UnixNano(), int64(inode)).Scan(&e.Media,
+		&mimeType, &sub, &value, &ext, &dto, &created, &mediaCreated, &exifJSON, &mediaInfo, &hash, &phash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("cache lookup %s: %v", path, err)
+		}
+		return fastdu.CacheEntry{}, false
+	}
+	e.Path, e.Size, e.Modtime, e.Inode = path, size, modtime, inode
+	e.MIME.Type, e.MIME.Subtype, e.MIME.Value, e.Extension = mimeType.String, sub.String, value.String, ext.String
+	e.DateTimeOriginal, e.CreateDate, e.MediaCreated = dto.Time, created.Time, mediaCreated.Time
+	e.Exif, e.MediaInfo = []byte(exifJSON.String), []byte(mediaInfo.String)
+	e.Hash, e.PHash = hash.String, phash.String
+	return e, true
+}
+
+// WriteCache stores the entries of a scan of roots that started at start
+// and drops the rows of files below the roots that no longer exist
+func (d *DBImpl) WriteCache(roots []string, start time.Time, entries []fastdu.CacheEntry) {
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("cache begin: %v", err)
+	}
+	stmt, err := tx.Prepare(upsertCache)
+	if err != nil {
+		log.Fatalf("cache prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		_, err := stmt.Exec(e.Path, e.Size, e.Modtime.UnixNano(), int64(e.Inode), e.Media,
+			e.MIME.Type, e.MIME.Subtype, e.MIME.Value, e.Extension,
+			nullTime(e.DateTimeOriginal), nullTime(e.CreateDate), nullTime(e.MediaCreated), string(e.Exif), string(e.MediaInfo),
+			e.Hash, e.PHash, start.UnixNano())
+		if err != nil {
+			log.Fatalf("insert cache %v", err)
+		}
+	}
+
+	var deleted int64
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		prefix := root
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		result, err := tx.Exec(deleteStaleCache, start.UnixNano(), root, prefix, prefix)
+		if err != nil {
+			log.Fatalf("delete stale cache rows %v", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("cache commit: %v", err)
+	}
+	log.Printf("cached files: %d, removed files: %d", len(entries), deleted)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+const (
+	insertCompanion = `INSERT OR REPLACE INTO companions
+	(path, primary_path, kind, scan_id, last_seen)
+	VALUES (?, ?, ?, ?, ?)`
+
+	// groups below a root are replaced by the groups of its latest scan
+	deleteCompanions = `DELETE FROM companions
+	WHERE primary_path = ? OR substr(primary_path, 1, length(?)) = ?`
+
+	selectCompanions = `SELECT primary_path, path, kind FROM companions ORDER BY primary_path, path`
+)
+
+// WriteCompanions replaces the companion groups below roots by groups
+func (d *DBImpl) WriteCompanions(roots []string, groups []fastdu.CompanionGroup) {
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("companions begin: %v", err)
+	}
+	for _, root := range roots {
+		root = filepath.Clean(absPath(root))
+		prefix := root
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		if _, err := tx.Exec(deleteCompanions, root, prefix, prefix); err != nil {
+			log.Fatalf("delete companions %v", err)
+		}
+	}
+
+	stmt, err := tx.Prepare(insertCompanion)
+	if err != nil {
+		log.Fatalf("companions prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	scanID, seen := d.seen()
+	rows := 0
+	for _, g := range groups {
+		for _, c := range g.Companions {
+			if _, err := stmt.Exec(absPath(c.Path), absPath(g.Primary), string(c.Kind), scanID, seen); err != nil {
+				log.Fatalf("insert companion %v", err)
+			}
+			rows++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("companions commit: %v", err)
+	}
+	log.Printf("companion groups: %d, companions: %d", len(groups), rows)
+}
+
+// Companions returns the companions of media files by the path of the media
+// file
+func (d *DBImpl) Companions() (map[string][]fastdu.Companion, error) {
+	return ReadCompanions(d.media)
+}
+
+// ReadCompanions returns the companions recorded in db by the path of the
+// media file they belong to
+func ReadCompanions(db *sql.DB) (map[string][]fastdu.Companion, error) {
+	rows, err := db.Query(selectCompanions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string][]fastdu.Companion)
+	for rows.Next() {
+		var primary string
+		var c fastdu.Companion
+		if err := rows.Scan(&primary, &c.Path, &c.Kind); err != nil {
+			return nil, err
+		}
+		res[primary] = append(res[primary], c)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ajoyka/fdu/fastdu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBImpl_Companions(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.WriteCompanions([]string{"/r"}, []fastdu.CompanionGroup{
+		{Primary: "/r/IMG_1.JPG", Companions: []fastdu.Companion{{Path: "/r/IMG_1.CR2", Kind: fastdu.CompanionRaw}, {Path: "/r/IMG_1.xmp", Kind: fastdu.CompanionSidecar}}},
+		{Primary: "/r/IMG_2.HEIC", Companions: []fastdu.Companion{{Path: "/r/IMG_2.MOV", Kind: fastdu.CompanionVideo}}},
+	})
+	d.WriteCompanions([]string{"/s"}, []fastdu.CompanionGroup{
+		{Primary: "/s/a.jpg", Companions: []fastdu.Companion{{Path: "/s/a.xmp", Kind: fastdu.CompanionSidecar}}},
+	})
+	// a rescan of /r replaces its groups
+	d.WriteCompanions([]string{"/r/"}, []fastdu.CompanionGroup{
+		{Primary: "/r/IMG_1.JPG", Companions: []fastdu.Companion{{Path: "/r/IMG_1.xmp", Kind: fastdu.CompanionSidecar}}},
+	})
+
+	got, err := d.Companions()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]fastdu.Companion{
+		"/r/IMG_1.JPG": {{Path: "/r/IMG_1.xmp", Kind: fastdu.CompanionSidecar}},
+		"/s/a.jpg":     {{Path: "/s/a.xmp", Kind: fastdu.CompanionSidecar}},
+	}, got)
+}
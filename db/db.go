@@ -10,92 +10,123 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ajoyka/fdu/fastdu"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	mediaDB    = "media.db"
-	mediaTable = `
-CREATE TABLE IF NOT EXISTS media (
-	name TEXT PRIMARY KEY,
-	size INTEGER,
-	datetime DATETIME,
-	exif_datetime_original DATETIME,
-	mime_type TEXT,
-	mime_subtype TEXT,
-	mime_value TEXT,
-	extension TEXT,
-	count INTEGER, -- if > 1 then duplicate occurences
-	file_size_mismatch INTEGER, -- 0 -> false, 1 -> true: sqlite does not have boolean type
-	suffix_common_path TEXT, -- common suffix if duplicate paths exist
-	max_common_path TEXT, -- common matching paths if duplicate paths exist
-	filepath TEXT,
-	exif_json TEXT
-)
-`
-	MediaDBCols      = "name, size, datetime, exif_datetime_original, mime_type, mime_subtype, mime_value, extension, count, file_size_mismatch, suffix_common_path, max_common_path, filepath, exif_json"
-	insertMediaTempl = `INSERT OR IGNORE INTO media 
- (%s)
- VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-
-	duplicatesTable = `
-CREATE TABLE IF NOT EXISTS duplicates (
-	datetime DATETIME,
-	name TEXT,
-	size INTEGER,
-	filepath TEXT PRIMARY KEY 
-)`
-
-	insertDuplicate = `INSERT OR IGNORE INTO duplicates
-	(datetime, name, size, filepath)
-	VALUES (?, ?, ?, ?)`
+	mediaDB     = "media.db"
+	MediaDBCols = "name, size, datetime, exif_datetime_original, mime_type, mime_subtype, mime_value, extension, count, file_size_mismatch, suffix_common_path, max_common_path, filepath, exif_json"
+	// rows are keyed by absolute path; rows of files seen again are
+	// updated and first_seen is kept
+	insertMediaTempl = `INSERT INTO media
+ (%s, path, device, inode, disk_size, hash, group_id, exif_create_date, media_created,
+ duration, width, height, latitude, longitude, altitude, tag_title, tag_artist, tag_album, tag_genre, tag_year,
+ scan_id, first_seen, last_seen)
+ VALUES (%s)
+ ON CONFLICT(path) DO UPDATE SET
+ name = excluded.name, size = excluded.size, datetime = excluded.datetime, exif_datetime_original = excluded.exif_datetime_original,
+ mime_type = excluded.mime_type, mime_subtype = excluded.mime_subtype, mime_value = excluded.mime_value,
+ extension = excluded.extension, count = excluded.count, file_size_mismatch = excluded.file_size_mismatch,
+ suffix_common_path = excluded.suffix_common_path, max_common_path = excluded.max_common_path,
+ filepath = excluded.filepath, exif_json = excluded.exif_json,
+ device = excluded.device, inode = excluded.inode, disk_size = excluded.disk_size,
+ hash = excluded.hash, group_id = excluded.group_id,
+ exif_create_date = excluded.exif_create_date, media_created = excluded.media_created,
+ duration = excluded.duration, width = excluded.width, height = excluded.height,
+ latitude = excluded.latitude, longitude = excluded.longitude, altitude = excluded.altitude,
+ tag_title = excluded.tag_title, tag_artist = excluded.tag_artist, tag_album = excluded.tag_album,
+ tag_genre = excluded.tag_genre, tag_year = excluded.tag_year,
+ scan_id = excluded.scan_id, last_seen = excluded.last_seen`
+
+	insertContentGroup = `INSERT INTO content_groups
+	(hash, size, count, wasted, scan_id, last_seen)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(hash) DO UPDATE SET
+	count = excluded.count, wasted = excluded.wasted, scan_id = excluded.scan_id, last_seen = excluded.last_seen
+	RETURNING id`
+
+	selectContentGroups = `SELECT g.id, g.hash, m.path, m.size, m.datetime
+	FROM content_groups g JOIN media m ON m.group_id = g.id
+	ORDER BY g.id, m.path`
+
+	// rows below a root that the current scan of that root didn't see
+	deleteStaleMedia = `DELETE FROM media WHERE (scan_id IS NULL OR scan_id <> ?)
+	AND (path = ? OR substr(path, 1, length(?)) = ?)`
+
+	// groups are counted again from the remaining rows; groups left with a
+	// single file are dissolved
+	countContentGroups  = `UPDATE content_groups SET count = (SELECT count(*) FROM media WHERE group_id = content_groups.id)`
+	ungroupMedia        = `UPDATE media SET group_id = NULL WHERE group_id IN (SELECT id FROM content_groups WHERE count < 2)`
+	deleteContentGroups = `DELETE FROM content_groups WHERE count < 2`
+	updateContentWasted = `UPDATE content_groups SET wasted = size * (count - 1)`
+
+	insertDuplicate = `INSERT INTO duplicates
+	(datetime, name, size, filepath, scan_id, last_seen)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(filepath) DO UPDATE SET
+	datetime = excluded.datetime, name = excluded.name, size = excluded.size,
+	scan_id = excluded.scan_id, last_seen = excluded.last_seen`
+
+	insertNearDuplicate = `INSERT OR REPLACE INTO near_duplicates
+	(cluster, name, size, phash, filepath)
+	VALUES (?, ?, ?, ?, ?)`
 )
 
 var (
-	insertMedia = fmt.Sprintf(insertMediaTempl, MediaDBCols)
+	insertMedia = fmt.Sprintf(insertMediaTempl, MediaDBCols, strings.TrimSuffix(strings.Repeat("?, ", 36), ", "))
 )
 
 type DB interface {
-	WriteMeta(meta map[string]*fastdu.Meta)       // write metadata to db
-	WriteDuplicates(meta map[string]*fastdu.Meta) // write duplicates to db
-	Close()                                       // close database
+	fastdu.Cache                                                             // look up unchanged files of previous scans
+	WriteMeta(meta map[string]*fastdu.Meta)                                  // write metadata to db
+	WriteDuplicates(meta map[string]*fastdu.Meta)                            // write duplicates to db
+	WriteNearDuplicates(clusters [][]*fastdu.Meta)                           // write perceptual hash clusters to db
+	WriteCache(roots []string, start time.Time, entries []fastdu.CacheEntry) // write file cache of a scan to db
+	WriteScan(scan Scan) (int64, error)                                      // record scan session that later rows are linked to
+	Scans() ([]Scan, error)                                                  // list recorded scan sessions
+	Disappeared(scanID int64) ([]string, error)                              // media files missing since the previous scan of the same roots
+	WriteScanFiles(files []fastdu.FileState)                                 // record the files observed by the current scan
+	ScanFiles(scanID int64) ([]fastdu.FileState, error)                      // files observed by a scan
+	DuplicateGroups() ([][]fastdu.FileState, error)                          // files with identical content, by content group
+	WriteCompanions(roots []string, groups []fastdu.CompanionGroup)          // replace companion groups below roots
+	Companions() (map[string][]fastdu.Companion, error)                      // companions by path of their media file
+	RunReport(r Report, limit int) (*ReportResult, error)                    // run a canned report
+	Close()                                                                  // close database
 }
 
 type DBImpl struct {
-	media *sql.DB
-	dups  *sql.DB // duplicate file db - for future use
+	media  *sql.DB
+	dups   *sql.DB   // duplicate file db - for future use
+	lookup *sql.Stmt // file cache lookup
+	scan   Scan      // current scan, set by WriteScan
 }
 
-// New creates a new db and tables associated with it if they don't exist
-func New() (DB, error) {
-	// set data source name
-	// Check link for avoiding db lock errors: https://github.com/mattn/go-sqlite3?tab=readme-ov-file#faq
-	dsn := fmt.Sprintf("file:%s?cache=shared", mediaDB)
-	db, err := sql.Open("sqlite3", dsn)
+// New opens the db configured by opts and creates or upgrades the tables
+// associated with it
+func New(opts Options) (DB, error) {
+	db, err := Open(opts)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	// ping database to verify connection
-	if err := db.Ping(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// create table
-	_, err = db.Exec(mediaTable)
-	if err != nil {
+	// create or upgrade the tables, see migrations/
+	if _, err := migrate(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	_, err = db.Exec(duplicatesTable)
+	lookup, err := db.Prepare(lookupCache)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return &DBImpl{
-		media: db,
+		media:  db,
+		lookup: lookup,
 	}, nil
 }
 
@@ -108,19 +139,18 @@ func New() (DB, error) {
 // }
 
 type job struct {
-	file string
+	file string // base file name
 	meta *fastdu.Meta
 }
 
 func (d *DBImpl) WriteDuplicates(meta map[string]*fastdu.Meta) {
-	var dupRows atomic.Uint64
-	var newRows atomic.Uint64
+	var rows atomic.Uint64
 
 	jobs := make(chan job)
 	go func() {
 		defer close(jobs)
-		for file, m := range meta {
-			jobs <- job{file, m}
+		for _, m := range meta {
+			jobs <- job{m.Name, m}
 		}
 	}()
 
@@ -128,6 +158,7 @@ func (d *DBImpl) WriteDuplicates(meta map[string]*fastdu.Meta) {
 	var wg sync.WaitGroup
 	wg.Add(numWorkers)
 
+	scanID, seen := d.seen()
 	stmt, err := d.media.Prepare(insertDuplicate)
 	if err != nil {
 		log.Fatalf("Duplicates prepare: %v", err)
@@ -140,34 +171,55 @@ func (d *DBImpl) WriteDuplicates(meta map[string]*fastdu.Meta) {
 			for job := range jobs {
 				m := job.meta
 				for _, dup := range m.Dups {
-					result, err := stmt.Exec(m.Modtime, job.file, dup.Size, dup.Name)
+					_, err := stmt.Exec(m.Modtime, job.file, dup.Size, dup.Name, scanID, seen)
 					if err != nil {
 						log.Fatalf("insert duplicate %v", err)
 					}
-
-					rowsAffected, err := result.RowsAffected()
-					if err != nil {
-						log.Fatalf("rows affected %v", err)
-					}
-					if rowsAffected == 0 {
-						dupRows.Add(1)
-					} else {
-						newRows.Add(1)
-					}
+					rows.Add(1)
 				}
 			}
 		}()
 	}
 	wg.Wait()
-	log.Printf("duplicate filepath insertion skip count: %d", dupRows.Load())
-	log.Printf("new duplicate row insertions: %d", newRows.Load())
+	log.Printf("duplicate rows written: %d", rows.Load())
 	log.Println("Inserted to duplicate rows database successfully")
 
 }
 
+// WriteNearDuplicates replaces the near duplicate clusters from a previous
+// scan with the specified clusters
+func (d *DBImpl) WriteNearDuplicates(clusters [][]*fastdu.Meta) {
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("near duplicates begin: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM near_duplicates"); err != nil {
+		log.Fatalf("near duplicates delete: %v", err)
+	}
+
+	stmt, err := tx.Prepare(insertNearDuplicate)
+	if err != nil {
+		log.Fatalf("near duplicates prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	rows := 0
+	for i, cluster := range clusters {
+		for _, m := range cluster {
+			if _, err := stmt.Exec(i+1, m.Name, m.Size, m.PHash, m.Path); err != nil {
+				log.Fatalf("insert near duplicate %v", err)
+			}
+			rows++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("near duplicates commit: %v", err)
+	}
+	log.Printf("near duplicate clusters: %d, rows: %d", len(clusters), rows)
+}
+
 func (d *DBImpl) WriteMeta(meta map[string]*fastdu.Meta) {
-	var dupRows atomic.Uint64
-	var newRows atomic.Uint64
+	var rows atomic.Uint64
 
 	numWorkers := 8
 
@@ -175,8 +227,8 @@ func (d *DBImpl) WriteMeta(meta map[string]*fastdu.Meta) {
 	jobs := make(chan job)
 	go func() { // has to be go routine as we are using unbuffered channel
 		defer close(jobs)
-		for file, m := range meta {
-			jobs <- job{file: file, meta: m}
+		for _, m := range meta {
+			jobs <- job{file: m.Name, meta: m}
 		}
 	}()
 
@@ -184,6 +236,8 @@ func (d *DBImpl) WriteMeta(meta map[string]*fastdu.Meta) {
 	// todo: user errGroup
 	wg.Add(numWorkers)
 
+	scanID, seen := d.seen()
+	groups := d.writeContentGroups(meta)
 	stmt, err := d.media.Prepare(insertMedia)
 	if err != nil {
 		log.Fatal(err)
@@ -212,40 +266,178 @@ func (d *DBImpl) WriteMeta(meta map[string]*fastdu.Meta) {
 				dateTimeOriginal.Valid = false
 				if m.MIME.Type == "image" {
 					dateTimeOriginal.Valid = true
-					dateTimeOriginal.Time = m.Exif.DateTimeOriginal()
+					dateTimeOriginal.Time = m.DateTimeOriginal
 				}
 				maxSuffixPath, maxCommonPath := findCommonPath(m.Dups)
-				result, err := stmt.Exec(job.file, m.Size, m.Modtime,
+				var groupID sql.NullInt64
+				if id, ok := groups[m.Hash]; ok && count > 1 {
+					groupID = sql.NullInt64{Int64: id, Valid: true}
+				}
+				args := []any{job.file, m.Size, m.Modtime,
 					dateTimeOriginal,
 					m.MIME.Type, m.MIME.Subtype, m.MIME.Value, m.Extension,
 					count, m.FileSizeMismatch,
 					maxSuffixPath,
 					maxCommonPath,
 					string(filepath),
-					string(exifData))
+					string(exifData),
+					absPath(m.Path), int64(m.Device), int64(m.Inode), m.DiskSize, m.Hash, groupID,
+					nullTime(m.CreateDate), nullTime(m.MediaCreated)}
+				args = append(args, mediaInfoValues(m)...)
+				_, err := stmt.Exec(append(args, scanID, seen, seen)...)
 				if err != nil {
 					log.Fatalf("insertion error %v\n", err)
 				}
-				rowsAffected, err := result.RowsAffected()
-				if err != nil {
-					log.Fatalf("rows affected error %v", err)
-				}
-				if rowsAffected == 0 {
-					dupRows.Add(1)
-				} else {
-					newRows.Add(1)
-				}
+				rows.Add(1)
 			}
 		}()
 	}
 	wg.Wait()
-	log.Printf("skipped duplicate row insertions: %d", dupRows.Load())
-	log.Printf("new rows added: %d", newRows.Load())
+	log.Printf("media rows written: %d", rows.Load())
+	d.pruneMedia()
 	log.Println("Inserted to media database successfully")
 }
 
+// pruneMedia drops the rows of files below the roots of the current scan that
+// it didn't see, and updates the content groups they belonged to
+func (d *DBImpl) pruneMedia() {
+	if d.scan.ID == 0 {
+		return
+	}
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("prune media begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	for _, root := range d.scan.Roots {
+		root = filepath.Clean(root)
+		prefix := root
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		result, err := tx.Exec(deleteStaleMedia, d.scan.ID, root, prefix, prefix)
+		if err != nil {
+			log.Fatalf("delete stale media rows %v", err)
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	var groups int64
+	for _, stmt := range []string{countContentGroups, ungroupMedia, deleteContentGroups, updateContentWasted} {
+		result, err := tx.Exec(stmt)
+		if err != nil {
+			log.Fatalf("prune content groups %v", err)
+		}
+		if stmt == deleteContentGroups {
+			groups, _ = result.RowsAffected()
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("prune media commit: %v", err)
+	}
+	log.Printf("removed media rows: %d, content groups: %d", deleted, groups)
+}
+
+// writeContentGroups records the groups of files with identical content and
+// returns the group ids by hash
+func (d *DBImpl) writeContentGroups(meta map[string]*fastdu.Meta) map[string]int64 {
+	res := make(map[string]int64)
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("content groups begin: %v", err)
+	}
+	stmt, err := tx.Prepare(insertContentGroup)
+	if err != nil {
+		log.Fatalf("content groups prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	scanID, seen := d.seen()
+	for _, m := range meta {
+		if _, ok := res[m.Hash]; ok || m.Hash == "" || len(m.Dups) < 2 {
+			continue
+		}
+		count := int64(len(m.Dups))
+		var id int64
+		err := stmt.QueryRow(m.Hash, m.Size, count, m.Size*(count-1), scanID, seen).Scan(&id)
+		if err != nil {
+			log.Fatalf("insert content group %v", err)
+		}
+		res[m.Hash] = id
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("content groups commit: %v", err)
+	}
+	log.Printf("content groups: %d", len(res))
+	return res
+}
+
+// DuplicateGroups returns the files of every content group that has more
+// than one file
+func (d *DBImpl) DuplicateGroups() ([][]fastdu.FileState, error) {
+	rows, err := d.media.Query(selectContentGroups)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res [][]fastdu.FileState
+	var group []fastdu.FileState
+	lastID := int64(-1)
+	for rows.Next() {
+		var id int64
+		var f fastdu.FileState
+		if err := rows.Scan(&id, &f.Hash, &f.Path, &f.Size, &f.Modtime); err != nil {
+			return nil, err
+		}
+		if id != lastID && len(group) > 1 {
+			res = append(res, group)
+		}
+		if id != lastID {
+			group, lastID = nil, id
+		}
+		group = append(group, f)
+	}
+	if len(group) > 1 {
+		res = append(res, group)
+	}
+	return res, rows.Err()
+}
+
+// mediaInfoValues returns the values of the container metadata columns of
+// media; unknown values are null
+func mediaInfoValues(m *fastdu.Meta) []any {
+	var lat, lon, alt sql.NullFloat64
+	if l := m.Location; l != nil {
+		lat = sql.NullFloat64{Float64: l.Latitude, Valid: true}
+		lon = sql.NullFloat64{Float64: l.Longitude, Valid: true}
+		if l.Altitude != nil {
+			alt = sql.NullFloat64{Float64: *l.Altitude, Valid: true}
+		}
+	}
+	text := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+	number := func(n int) sql.NullInt64 { return sql.NullInt64{Int64: int64(n), Valid: n > 0} }
+	return []any{
+		sql.NullFloat64{Float64: m.Duration.Seconds(), Valid: m.Duration > 0},
+		number(m.Width), number(m.Height), lat, lon, alt,
+		text(m.Tags.Title), text(m.Tags.Artist), text(m.Tags.Album), text(m.Tags.Genre), number(m.Tags.Year),
+	}
+}
+
+// absPath returns the absolute path of a file; paths are kept as is if the
+// working dir is unknown
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
 func (d *DBImpl) Close() {
-	d.Close()
+	d.lookup.Close()
+	d.media.Close()
 }
 
 // findCommonPath finds the common path suffix from the bottom to the top
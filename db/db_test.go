@@ -1,9 +1,13 @@
 package db
 
 import (
+	"database/sql"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ajoyka/fdu/fastdu"
+	"github.com/h2non/filetype/types"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 )
@@ -88,3 +92,231 @@ func Test_findCommonPath(t *testing.T) {
 		})
 	}
 }
+
+func TestDBImpl_Cache(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	mtime := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	e := fastdu.CacheEntry{Path: "/r/a/x.jpg", Size: 10, Modtime: mtime, Inode: 7, Media: true,
+		Type: types.NewType("jpg", "image/jpeg"), DateTimeOriginal: mtime, MediaCreated: mtime.Add(time.Hour), Hash: "abc",
+		MediaInfo: []byte(`{"Duration":1000000000}`)}
+	d.WriteCache([]string{"/r"}, time.Now(), []fastdu.CacheEntry{e, {Path: "/r/b.txt", Size: 3, Modtime: mtime}})
+
+	got, ok := d.Lookup(e.Path, e.Size, e.Modtime, e.Inode)
+	if assert.True(t, ok) {
+		assert.Equal(t, "image", got.MIME.Type)
+		assert.Equal(t, "abc", got.Hash)
+		assert.True(t, mtime.Equal(got.DateTimeOriginal))
+		assert.True(t, mtime.Add(time.Hour).Equal(got.MediaCreated))
+		assert.True(t, got.CreateDate.IsZero())
+		assert.Equal(t, `{"Duration":1000000000}`, string(got.MediaInfo))
+	}
+	_, ok = d.Lookup(e.Path, e.Size, mtime.Add(time.Nanosecond), e.Inode)
+	assert.False(t, ok)
+
+	// files not seen by a later scan of the root are dropped
+	d.WriteCache([]string{"/r/"}, time.Now(), []fastdu.CacheEntry{e})
+	_, ok = d.Lookup(e.Path, e.Size, e.Modtime, e.Inode)
+	assert.True(t, ok)
+	_, ok = d.Lookup("/r/b.txt", 3, mtime, 0)
+	assert.False(t, ok)
+}
+
+func TestDBImpl_Scans(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	meta := func(path string, size int64) *fastdu.Meta {
+		return &fastdu.Meta{Name: filepath.Base(path), Path: path, Size: size,
+			Type: types.NewType("jpg", "image/jpeg"), Dups: []fastdu.Duplicate{{Name: path, Size: size}}}
+	}
+	first := map[string]*fastdu.Meta{"/r/a.jpg": meta("/r/a.jpg", 1), "/r/b.jpg": meta("/r/b.jpg", 2)}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	id1, err := d.WriteScan(Scan{Roots: []string{"/r"}, Start: start, End: start.Add(time.Minute), Files: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(first)
+	d.WriteScanFiles([]fastdu.FileState{{Path: "/r/a.jpg", Size: 1, Media: true}, {Path: "/r/b.jpg", Size: 2, Media: true},
+		{Path: "/r/notes.txt", Size: 3}})
+
+	second := map[string]*fastdu.Meta{"/r/a.jpg": meta("/r/a.jpg", 5)}
+	id2, err := d.WriteScan(Scan{Roots: []string{"/r"}, Start: start.AddDate(0, 1, 0), Files: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(second)
+	d.WriteScanFiles([]fastdu.FileState{{Path: "/r/a.jpg", Size: 5, Media: true}})
+
+	scans, err := d.Scans()
+	if assert.NoError(t, err) && assert.Len(t, scans, 2) {
+		assert.Equal(t, id1, scans[0].ID)
+		assert.Equal(t, []string{"/r"}, scans[0].Roots)
+		assert.Equal(t, int64(2), scans[0].Files)
+	}
+	gone, err := d.Disappeared(id2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/r/b.jpg"}, gone)
+	files, err := d.ScanFiles(id1)
+	if assert.NoError(t, err) && assert.Len(t, files, 3) {
+		assert.Equal(t, fastdu.FileState{Path: "/r/notes.txt", Size: 3, Modtime: files[2].Modtime}, files[2])
+	}
+
+	// the row of a file seen again is updated but keeps its first sighting
+	var size, scanID int64
+	var firstSeen, lastSeen time.Time
+	err = d.(*DBImpl).media.QueryRow("SELECT size, scan_id, first_seen, last_seen FROM media WHERE name = 'a.jpg'").
+		Scan(&size, &scanID, &firstSeen, &lastSeen)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(5), size)
+		assert.Equal(t, id2, scanID)
+		assert.True(t, start.Equal(firstSeen))
+		assert.True(t, start.AddDate(0, 1, 0).Equal(lastSeen))
+	}
+}
+
+func TestDBImpl_WriteMeta(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// two cameras with the same file names; one photo was copied
+	jpeg := types.NewType("jpg", "image/jpeg")
+	a := &fastdu.Meta{Name: "IMG_0001.JPG", Path: "/cam1/IMG_0001.JPG", Size: 100, Inode: 1, Type: jpeg, Hash: "h1"}
+	b := &fastdu.Meta{Name: "IMG_0001.JPG", Path: "/cam2/IMG_0001.JPG", Size: 200, Inode: 2, Type: jpeg}
+	c := &fastdu.Meta{Name: "copy.jpg", Path: "/backup/copy.jpg", Size: 100, Inode: 3, Type: jpeg, Hash: "h1"}
+	dups := []fastdu.Duplicate{{Name: a.Path, Size: 100, Hash: "h1"}, {Name: c.Path, Size: 100, Hash: "h1"}}
+	a.Dups = append([]fastdu.Duplicate(nil), dups...)
+	c.Dups = append([]fastdu.Duplicate(nil), dups...)
+	b.Dups = []fastdu.Duplicate{{Name: b.Path, Size: 200}}
+	d.WriteMeta(map[string]*fastdu.Meta{a.Path: a, b.Path: b, c.Path: c})
+
+	media := d.(*DBImpl).media
+	var rows int
+	media.QueryRow("SELECT count(*) FROM media WHERE name = 'IMG_0001.JPG'").Scan(&rows)
+	assert.Equal(t, 2, rows)
+
+	var groupID, count, wasted int64
+	err = media.QueryRow("SELECT id, count, wasted FROM content_groups WHERE hash = 'h1'").Scan(&groupID, &count, &wasted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(2), count)
+		assert.Equal(t, int64(100), wasted)
+	}
+	var paths []string
+	res, err := media.Query("SELECT path FROM media WHERE group_id = ? ORDER BY path", groupID)
+	if assert.NoError(t, err) {
+		for res.Next() {
+			var p string
+			res.Scan(&p)
+			paths = append(paths, p)
+		}
+		res.Close()
+	}
+	assert.Equal(t, []string{"/backup/copy.jpg", "/cam1/IMG_0001.JPG"}, paths)
+
+	groups, err := d.DuplicateGroups()
+	if assert.NoError(t, err) && assert.Len(t, groups, 1) {
+		assert.Equal(t, "/backup/copy.jpg", groups[0][0].Path)
+		assert.Equal(t, "/cam1/IMG_0001.JPG", groups[0][1].Path)
+		assert.Equal(t, "h1", groups[0][1].Hash)
+		assert.Equal(t, int64(100), groups[0][1].Size)
+	}
+}
+
+// TestDBImpl_PruneMedia scans a root again after a copy was deleted: the
+// row of the copy and its content group are gone, rows of other roots stay
+func TestDBImpl_PruneMedia(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	jpeg := types.NewType("jpg", "image/jpeg")
+	meta := func(path string, dups ...string) *fastdu.Meta {
+		m := &fastdu.Meta{Name: filepath.Base(path), Path: path, Size: 100, Type: jpeg, Hash: "h1"}
+		for _, dup := range dups {
+			m.Dups = append(m.Dups, fastdu.Duplicate{Name: dup, Size: 100, Hash: "h1"})
+		}
+		return m
+	}
+	paths := []string{"/r/a.jpg", "/r/b.jpg", "/other/c.jpg"}
+	if _, err := d.WriteScan(Scan{Roots: []string{"/r", "/other"}, Start: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(map[string]*fastdu.Meta{paths[0]: meta(paths[0], paths...), paths[1]: meta(paths[1], paths...),
+		paths[2]: meta(paths[2], paths...)})
+	groups, err := d.DuplicateGroups()
+	if assert.NoError(t, err) && assert.Len(t, groups, 1) {
+		assert.Len(t, groups[0], 3)
+	}
+
+	// b.jpg was deleted
+	if _, err := d.WriteScan(Scan{Roots: []string{"/r"}, Start: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(map[string]*fastdu.Meta{paths[0]: meta(paths[0], paths[0])})
+	media := d.(*DBImpl).media
+	var rows, count int64
+	media.QueryRow("SELECT count(*) FROM media").Scan(&rows)
+	assert.Equal(t, int64(2), rows)
+	// the scan of /r didn't group a.jpg, which leaves c.jpg alone in the group
+	media.QueryRow("SELECT count(*) FROM content_groups").Scan(&count)
+	assert.Equal(t, int64(0), count)
+	media.QueryRow("SELECT count(*) FROM media WHERE group_id IS NOT NULL").Scan(&count)
+	assert.Equal(t, int64(0), count)
+	groups, err = d.DuplicateGroups()
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestDBImpl_WriteMetaMediaInfo(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	video := &fastdu.Meta{Name: "VID_1.mp4", Path: "/r/VID_1.mp4", Size: 100, Type: types.NewType("mp4", "video/mp4"),
+		Duration: 90500 * time.Millisecond, Width: 1080, Height: 1920,
+		Location: &fastdu.Location{Latitude: 37.3349, Longitude: -122.009},
+		Tags:     fastdu.MediaTags{Title: "Trip", Year: 2019}}
+	song := &fastdu.Meta{Name: "song.mp3", Path: "/r/song.mp3", Size: 50, Type: types.NewType("mp3", "audio/mpeg"),
+		Tags: fastdu.MediaTags{Artist: "Ann"}}
+	for _, m := range []*fastdu.Meta{video, song} {
+		m.Dups = []fastdu.Duplicate{{Name: m.Path, Size: m.Size}}
+	}
+	d.WriteMeta(map[string]*fastdu.Meta{video.Path: video, song.Path: song})
+
+	media := d.(*DBImpl).media
+	var duration, lat, lon, alt sql.NullFloat64
+	var width, height, year sql.NullInt64
+	var title, artist sql.NullString
+	query := "SELECT duration, width, height, latitude, longitude, altitude, tag_title, tag_artist, tag_year FROM media WHERE path = ?"
+	err = media.QueryRow(query, video.Path).Scan(&duration, &width, &height, &lat, &lon, &alt, &title, &artist, &year)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 90.5, duration.Float64)
+		assert.Equal(t, int64(1080), width.Int64)
+		assert.Equal(t, int64(1920), height.Int64)
+		assert.Equal(t, 37.3349, lat.Float64)
+		assert.Equal(t, -122.009, lon.Float64)
+		assert.False(t, alt.Valid) // the location has no altitude
+		assert.Equal(t, "Trip", title.String)
+		assert.False(t, artist.Valid)
+		assert.Equal(t, int64(2019), year.Int64)
+	}
+	err = media.QueryRow(query, song.Path).Scan(&duration, &width, &height, &lat, &lon, &alt, &title, &artist, &year)
+	if assert.NoError(t, err) {
+		assert.False(t, duration.Valid)
+		assert.False(t, width.Valid)
+		assert.False(t, lat.Valid)
+		assert.Equal(t, "Ann", artist.String)
+	}
+}
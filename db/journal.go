@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// states of replicate jobs
+const (
+	JobPending = "pending"
+	JobCopying = "copying"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+const (
+	insertJob = `INSERT INTO replicate_jobs (source, destination, mode, size, state, updated)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(source, destination) DO UPDATE SET mode = excluded.mode, size = excluded.size,
+		state = excluded.state, result = NULL, final = NULL, error = NULL, updated = excluded.updated
+	WHERE replicate_jobs.mode IS NOT excluded.mode`
+
+	updateJob = `UPDATE replicate_jobs SET mode = ?, state = ?, result = ?, final = ?, error = ?, updated = ?
+	WHERE source = ? AND destination = ?`
+
+	selectJobs = `SELECT source, destination, coalesce(mode, ''), size, state, coalesce(result, ''), coalesce(final, ''),
+	coalesce(error, ''), updated FROM replicate_jobs`
+)
+
+// Job is a file copy of replicate
+type Job struct {
+	Source      string
+	Destination string // planned destination
+	Mode        string // transfer mode: copy, move, hardlink, symlink or reflink
+	Size        int64
+	State       string
+	Result      string // outcome of a done job
+	Final       string // path of the copy
+	Error       string // error of a failed job
+	Updated     time.Time
+}
+
+// Journal records the state of replicate jobs so that an interrupted run can
+// be resumed
+type Journal struct {
+	db *sql.DB
+	mu sync.Mutex // writes are serialized since the connections share a cache
+}
+
+// OpenJournal creates or upgrades the tables of db and returns its journal
+func OpenJournal(db *sql.DB) (*Journal, error) {
+	if _, err := migrate(db); err != nil {
+		return nil, err
+	}
+	return &Journal{db: db}, nil
+}
+
+// Add records jobs as pending; the state of jobs that are already known is
+// kept unless they were recorded with another mode, since a link isn't a
+// copy
+func (j *Journal) Add(jobs []Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(insertJob)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, job := range jobs {
+		if _, err := stmt.Exec(job.Source, job.Destination, job.Mode, job.Size, JobPending, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Update records the state of a job
+func (j *Journal) Update(job Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.db.Exec(updateJob, job.Mode, job.State, job.Result, job.Final, job.Error, time.Now(),
+		job.Source, job.Destination)
+	return err
+}
+
+// Jobs returns the recorded jobs, optionally only those in the specified
+// states
+func (j *Journal) Jobs(states ...string) ([]Job, error) {
+	query, args := selectJobs, []any{}
+	for i, s := range states {
+		if i == 0 {
+			query += " WHERE state IN (?"
+		} else {
+			query += ", ?"
+		}
+		args = append(args, s)
+	}
+	if len(states) > 0 {
+		query += ")"
+	}
+	rows, err := j.db.Query(query+" ORDER BY source, destination", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Job
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(&job.Source, &job.Destination, &job.Mode, &job.Size, &job.State, &job.Result,
+			&job.Final, &job.Error, &job.Updated); err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	return res, rows.Err()
+}
+
+// Clear forgets all jobs
+func (j *Journal) Clear() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.db.Exec("DELETE FROM replicate_jobs")
+	return err
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := OpenJournal(openTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := []Job{
+		{Source: "/src/a.jpg", Destination: "/dst/2019/a.jpg", Mode: "copy", Size: 10},
+		{Source: "/src/b.jpg", Destination: "/dst/2019/b.jpg", Mode: "copy", Size: 20},
+	}
+	assert.NoError(t, j.Add(jobs))
+
+	done := jobs[0]
+	done.State, done.Result, done.Final = JobDone, "renamed", "/dst/2019/a_1.jpg"
+	assert.NoError(t, j.Update(done))
+	failed := jobs[1]
+	failed.State, failed.Error = JobFailed, "disk full"
+	assert.NoError(t, j.Update(failed))
+
+	// a rerun adds the same jobs again and keeps their state
+	assert.NoError(t, j.Add(jobs))
+	got, err := j.Jobs()
+	if assert.NoError(t, err) && assert.Len(t, got, 2) {
+		assert.Equal(t, JobDone, got[0].State)
+		assert.Equal(t, "/dst/2019/a_1.jpg", got[0].Final)
+		assert.Equal(t, "renamed", got[0].Result)
+		assert.Equal(t, JobFailed, got[1].State)
+		assert.Equal(t, "disk full", got[1].Error)
+	}
+	got, err = j.Jobs(JobPending, JobFailed)
+	if assert.NoError(t, err) && assert.Len(t, got, 1) {
+		assert.Equal(t, "/src/b.jpg", got[0].Source)
+	}
+
+	// a rerun in another mode redoes the jobs
+	jobs[0].Mode = "symlink"
+	assert.NoError(t, j.Add(jobs[:1]))
+	got, err = j.Jobs(JobPending)
+	if assert.NoError(t, err) && assert.Len(t, got, 1) {
+		assert.Equal(t, "/src/a.jpg", got[0].Source)
+		assert.Equal(t, "symlink", got[0].Mode)
+		assert.Empty(t, got[0].Final)
+		assert.Empty(t, got[0].Result)
+	}
+
+	assert.NoError(t, j.Clear())
+	got, _ = j.Jobs()
+	assert.Empty(t, got)
+}
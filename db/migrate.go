@@ -0,0 +1,189 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations are applied in order of their version prefix: NNNN_name.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT,
+	applied DATETIME
+)`
+
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+	before  func(tx *sql.Tx) error // runs ahead of stmts, optional
+}
+
+// before are the steps of migrations that need code, by version
+var before = map[int]func(tx *sql.Tx) error{
+	12: noticeMediaByName,
+}
+
+// noticeMediaByName reports the rows of releases that keyed media by file
+// name; they are dropped since their paths may be relative to the dir of
+// the scan that found them
+func noticeMediaByName(tx *sql.Tx) error {
+	var n int
+	if err := tx.QueryRow("SELECT count(*) FROM media_by_name").Scan(&n); err != nil {
+		return nil // no legacy table
+	}
+	if n > 0 {
+		log.Printf("dropping %d media rows keyed by file name of an old release; scan again to catalog these files", n)
+	}
+	return nil
+}
+
+// loadMigrations returns the embedded migrations sorted by version
+func loadMigrations() ([]migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var res []migration
+	for _, f := range files {
+		prefix, _, ok := strings.Cut(f.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name doesn't start with a version", f.Name())
+		}
+		b, err := migrationFiles.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, migration{version, f.Name(), splitStatements(string(b)), before[version]})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].version < res[j].version })
+	for i, m := range res {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s: expecting version %d", m.name, i+1)
+		}
+	}
+	return res, nil
+}
+
+// splitStatements splits a sql script into statements at the semicolons
+// that aren't in quotes or comments; comments are removed. Statements with
+// semicolons of their own, such as the BEGIN ... END body of a trigger,
+// aren't supported.
+func splitStatements(script string) []string {
+	var res []string
+	var b strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			res = append(res, stmt)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case strings.HasPrefix(script[i:], "--"):
+			// up to the end of the line
+			j := strings.IndexByte(script[i:], '\n')
+			if j < 0 {
+				j = len(script) - i
+			}
+			i += j - 1
+		case strings.HasPrefix(script[i:], "/*"):
+			j := strings.Index(script[i+2:], "*/")
+			if j < 0 {
+				j = len(script) - i - 4
+			}
+			b.WriteByte(' ')
+			i += j + 3
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			// quotes in strings are doubled, which reads as two strings
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := strings.IndexByte(script[i+1:], end)
+			if j < 0 {
+				j = len(script) - i - 2
+			}
+			b.WriteString(script[i : i+j+2])
+			i += j + 1
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return res
+}
+
+// legacyVersion is the last migration of the schema of releases before
+// migrations existed
+const legacyVersion = 4
+
+// migrate brings the schema of db up to the latest version. Every migration
+// runs in its own transaction and is recorded in schema_version. Databases
+// created before migrations existed have no schema_version; their tables
+// and columns are picked up since the migrations up to legacyVersion skip
+// columns that already exist. Later migrations fail on them.
+func migrate(db *sql.DB) (int, error) {
+	if _, err := db.Exec(schemaVersionTable); err != nil {
+		return 0, err
+	}
+	var current int
+	if err := db.QueryRow("SELECT coalesce(max(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return 0, err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return current, err
+	}
+	if current > len(migrations) {
+		return current, fmt.Errorf("schema version %d is newer than this program supports (%d)", current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := apply(db, m); err != nil {
+			return current, fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		current = m.version
+	}
+	return current, nil
+}
+
+func apply(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.before != nil {
+		if err := m.before(tx); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range m.stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil && !(m.version <= legacyVersion && strings.Contains(err.Error(), "duplicate column name")) {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func openTemp(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "media.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_migrate(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTemp(t)
+	version, err := migrate(db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	// migrations are only applied once
+	version, err = migrate(db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+	var applied int
+	db.QueryRow("SELECT count(*) FROM schema_version").Scan(&applied)
+	assert.Equal(t, len(migrations), applied)
+}
+
+func Test_migrateLegacy(t *testing.T) {
+	// database of a release before migrations, which already had some of
+	// the later columns
+	db := openTemp(t)
+	for _, stmt := range []string{
+		"CREATE TABLE media (name TEXT PRIMARY KEY, size INTEGER, filepath TEXT)",
+		"CREATE TABLE duplicates (datetime DATETIME, name TEXT, size INTEGER, filepath TEXT PRIMARY KEY, scan_id INTEGER)",
+		"INSERT INTO media (name, size, filepath) VALUES ('a.jpg', 1, '/a.jpg')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := migrate(db)
+	assert.NoError(t, err)
+	// rows keyed by name are dropped
+	var tables int
+	db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'media_by_name'").Scan(&tables)
+	assert.Equal(t, 0, tables)
+	var rows int
+	db.QueryRow("SELECT count(*) FROM media").Scan(&rows)
+	assert.Equal(t, 0, rows)
+}
+
+func Test_splitStatements(t *testing.T) {
+	script := `-- comment; with a semicolon
+CREATE TABLE a (x INTEGER); -- trailing
+ALTER TABLE a ADD COLUMN y TEXT;
+`
+	assert.Equal(t, []string{"CREATE TABLE a (x INTEGER)", "ALTER TABLE a ADD COLUMN y TEXT"}, splitStatements(script))
+
+	// semicolons and dashes in strings and block comments
+	script = `INSERT INTO a (y) VALUES ('x; -- y', 'it''s'); /* a; b */ DELETE FROM "a;b"`
+	assert.Equal(t, []string{`INSERT INTO a (y) VALUES ('x; -- y', 'it''s')`, `DELETE FROM "a;b"`}, splitStatements(script))
+}
+
+func Test_applyDuplicateColumn(t *testing.T) {
+	db := openTemp(t)
+	_, err := db.Exec(schemaVersionTable)
+	assert.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE a (x INTEGER)")
+	assert.NoError(t, err)
+
+	// only the migrations of legacy databases skip existing columns
+	dup := []string{"ALTER TABLE a ADD COLUMN x INTEGER"}
+	assert.NoError(t, apply(db, migration{version: legacyVersion, name: "legacy.sql", stmts: dup}))
+	assert.ErrorContains(t, apply(db, migration{version: legacyVersion + 1, name: "later.sql", stmts: dup}), "duplicate column name")
+}
@@ -0,0 +1,24 @@
+-- tables of the first releases; they may already exist in old databases
+CREATE TABLE IF NOT EXISTS media (
+	name TEXT PRIMARY KEY,
+	size INTEGER,
+	datetime DATETIME,
+	exif_datetime_original DATETIME,
+	mime_type TEXT,
+	mime_subtype TEXT,
+	mime_value TEXT,
+	extension TEXT,
+	count INTEGER, -- if > 1 then duplicate occurences
+	file_size_mismatch INTEGER, -- 0 -> false, 1 -> true: sqlite does not have boolean type
+	suffix_common_path TEXT, -- common suffix if duplicate paths exist
+	max_common_path TEXT, -- common matching paths if duplicate paths exist
+	filepath TEXT,
+	exif_json TEXT
+);
+
+CREATE TABLE IF NOT EXISTS duplicates (
+	datetime DATETIME,
+	name TEXT,
+	size INTEGER,
+	filepath TEXT PRIMARY KEY
+);
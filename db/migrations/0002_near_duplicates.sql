@@ -0,0 +1,9 @@
+-- images that look alike by perceptual hash; cluster ids are only
+-- meaningful within the scan that produced them
+CREATE TABLE IF NOT EXISTS near_duplicates (
+	cluster INTEGER,
+	name TEXT,
+	size INTEGER,
+	phash TEXT,
+	filepath TEXT PRIMARY KEY
+);
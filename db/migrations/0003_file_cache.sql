@@ -0,0 +1,19 @@
+-- content derived data of every file seen by a scan; rows are only
+-- valid while size, mtime and inode match the file on disk
+CREATE TABLE IF NOT EXISTS file_cache (
+	filepath TEXT PRIMARY KEY,
+	size INTEGER,
+	mtime_ns INTEGER, -- nanoseconds since the epoch, compared exactly
+	inode INTEGER,
+	media INTEGER, -- 0 -> not an image, audio or video file
+	mime_type TEXT,
+	mime_subtype TEXT,
+	mime_value TEXT,
+	extension TEXT,
+	exif_datetime_original DATETIME,
+	exif_create_date DATETIME,
+	exif_json TEXT,
+	hash TEXT,
+	phash TEXT,
+	last_seen_ns INTEGER -- start of the latest scan that saw the file
+);
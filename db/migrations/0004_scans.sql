@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS scans (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	roots TEXT, -- json list of scanned roots
+	start_time DATETIME,
+	end_time DATETIME,
+	flags TEXT, -- command line flags of the scan
+	files INTEGER,
+	bytes INTEGER,
+	disk_bytes INTEGER,
+	counters TEXT -- json encoded fastdu.CountersSnapshot
+);
+
+-- media files observed by every scan; used to compare scans and to
+-- find files that disappeared
+CREATE TABLE IF NOT EXISTS scan_files (
+	scan_id INTEGER REFERENCES scans(id),
+	filepath TEXT,
+	size INTEGER,
+	modtime DATETIME,
+	hash TEXT,
+	PRIMARY KEY (scan_id, filepath)
+);
+
+ALTER TABLE media ADD COLUMN scan_id INTEGER; -- latest scan that saw the file
+ALTER TABLE media ADD COLUMN first_seen DATETIME;
+ALTER TABLE media ADD COLUMN last_seen DATETIME;
+ALTER TABLE duplicates ADD COLUMN scan_id INTEGER;
+ALTER TABLE duplicates ADD COLUMN last_seen DATETIME;
@@ -0,0 +1,45 @@
+-- media rows were keyed by base file name so files sharing a name collapsed
+-- into one row; the old table is kept for reference and the next scan fills
+-- the new one
+ALTER TABLE media RENAME TO media_by_name;
+
+-- files with identical content
+CREATE TABLE content_groups (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash TEXT UNIQUE, -- sha256 of the content
+	size INTEGER,
+	count INTEGER, -- number of files with this content
+	wasted INTEGER, -- size * (count - 1)
+	scan_id INTEGER,
+	last_seen DATETIME
+);
+
+CREATE TABLE media (
+	path TEXT PRIMARY KEY, -- absolute path
+	device INTEGER, -- device and inode identify hard links of the same file
+	inode INTEGER,
+	name TEXT,
+	size INTEGER,
+	disk_size INTEGER,
+	datetime DATETIME,
+	exif_datetime_original DATETIME,
+	mime_type TEXT,
+	mime_subtype TEXT,
+	mime_value TEXT,
+	extension TEXT,
+	hash TEXT, -- only computed for files that share a size with another file
+	group_id INTEGER REFERENCES content_groups(id), -- NULL if the content is unique
+	count INTEGER, -- if > 1 then duplicate occurences
+	file_size_mismatch INTEGER, -- 0 -> false, 1 -> true: sqlite does not have boolean type
+	suffix_common_path TEXT, -- common suffix if duplicate paths exist
+	max_common_path TEXT, -- common matching paths if duplicate paths exist
+	filepath TEXT, -- json list of files with identical content
+	exif_json TEXT,
+	scan_id INTEGER, -- latest scan that saw the file
+	first_seen DATETIME,
+	last_seen DATETIME
+);
+
+CREATE INDEX media_name ON media (name);
+CREATE INDEX media_inode ON media (device, inode);
+CREATE INDEX media_group ON media (group_id);
@@ -0,0 +1,8 @@
+-- candidate capture dates of media files; replicate picks one of them by a
+-- configurable precedence
+ALTER TABLE media ADD COLUMN exif_create_date DATETIME;
+ALTER TABLE media ADD COLUMN media_created DATETIME; -- creation time of the video container
+ALTER TABLE file_cache ADD COLUMN media_created DATETIME;
+
+-- videos were cached before their creation time was read
+DELETE FROM file_cache WHERE mime_type = 'video';
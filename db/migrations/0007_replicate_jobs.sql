@@ -0,0 +1,14 @@
+-- journal of replicate: a rerun skips the jobs that are done
+CREATE TABLE IF NOT EXISTS replicate_jobs (
+	source TEXT,
+	destination TEXT, -- planned destination
+	size INTEGER,
+	state TEXT, -- pending, copying, done or failed
+	result TEXT, -- outcome of a done job: copied, renamed, replaced, identical or kept
+	final TEXT, -- path of the copy; differs from destination when renamed
+	error TEXT, -- error of a failed job
+	updated DATETIME,
+	PRIMARY KEY (source, destination)
+);
+
+CREATE INDEX IF NOT EXISTS replicate_jobs_state ON replicate_jobs (state);
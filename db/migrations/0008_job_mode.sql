@@ -0,0 +1,4 @@
+-- transfer mode of replicate jobs: a rerun in another mode redoes the jobs.
+-- Jobs of earlier runs have no mode and are redone once; copies that exist
+-- are found identical.
+ALTER TABLE replicate_jobs ADD COLUMN mode TEXT;
@@ -0,0 +1,11 @@
+-- files that belong to a photo or video: sidecars, the RAW of a RAW+JPEG
+-- pair, the video of a Live Photo; they are copied and moved with it
+CREATE TABLE IF NOT EXISTS companions (
+	path TEXT PRIMARY KEY,
+	primary_path TEXT, -- media file the companion belongs to
+	kind TEXT, -- image, raw, video or sidecar
+	scan_id INTEGER,
+	last_seen DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS companions_primary ON companions (primary_path);
@@ -0,0 +1,17 @@
+-- metadata of video and audio containers: MP4/MOV atoms, Matroska
+-- elements, ID3 tags and Vorbis comments
+ALTER TABLE media ADD COLUMN duration REAL; -- seconds
+ALTER TABLE media ADD COLUMN width INTEGER; -- frame size of videos
+ALTER TABLE media ADD COLUMN height INTEGER;
+ALTER TABLE media ADD COLUMN latitude REAL; -- where a video was recorded
+ALTER TABLE media ADD COLUMN longitude REAL;
+ALTER TABLE media ADD COLUMN altitude REAL;
+ALTER TABLE media ADD COLUMN tag_title TEXT; -- ID3, Vorbis, MP4 and Matroska tags
+ALTER TABLE media ADD COLUMN tag_artist TEXT;
+ALTER TABLE media ADD COLUMN tag_album TEXT;
+ALTER TABLE media ADD COLUMN tag_genre TEXT;
+ALTER TABLE media ADD COLUMN tag_year INTEGER;
+ALTER TABLE file_cache ADD COLUMN media_info TEXT; -- json encoded fastdu.MediaInfo
+
+-- videos and audio files were cached before their metadata was read
+DELETE FROM file_cache WHERE mime_type IN ('video', 'audio');
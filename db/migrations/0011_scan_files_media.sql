@@ -0,0 +1,3 @@
+-- scans record all their files; media tells media files apart. Rows of
+-- earlier scans only hold media files and have no media value.
+ALTER TABLE scan_files ADD COLUMN media INTEGER;
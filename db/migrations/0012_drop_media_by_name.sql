@@ -0,0 +1,3 @@
+-- media rows keyed by base file name, kept aside by 0005, are dropped; the
+-- rows that are dropped are reported
+DROP TABLE IF EXISTS media_by_name;
@@ -0,0 +1,3 @@
+-- images and videos were cached before the Live Photo content identifier
+-- that pairs the still with its video was read
+DELETE FROM file_cache WHERE mime_type IN ('image', 'video');
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPath is the database file used when no path is configured
+	DefaultPath = mediaDB
+	// DefaultJournalMode lets readers such as replicate run while a scan
+	// writes to the database
+	DefaultJournalMode = "WAL"
+	// DefaultBusyTimeout is how long a connection waits for locks held by
+	// other connections before failing
+	DefaultBusyTimeout = 5 * time.Second
+	// DefaultCache is the sqlite cache mode
+	DefaultCache = "shared"
+)
+
+// Options configures the database opened by New and Open; zero values
+// select the defaults
+type Options struct {
+	Path        string        // database file
+	DSN         string        // complete data source name, e.g. "file:a.db?mode=ro"; overrides the other options
+	JournalMode string        // sqlite journal mode: WAL, DELETE, TRUNCATE, ...
+	BusyTimeout time.Duration // wait for locks held by other connections
+	Cache       string        // shared or private
+}
+
+// PathOrDSN returns options for a database file path, or for a data source
+// name if s starts with "file:"; it is used for command line flags
+func PathOrDSN(s string) Options {
+	if strings.HasPrefix(s, "file:") {
+		return Options{DSN: s}
+	}
+	return Options{Path: s}
+}
+
+// dsn returns the data source name of the options
+func (o Options) dsn() string {
+	if o.DSN != "" {
+		return o.DSN
+	}
+	if o.Path == "" {
+		o.Path = DefaultPath
+	}
+	if o.JournalMode == "" {
+		o.JournalMode = DefaultJournalMode
+	}
+	if o.BusyTimeout == 0 {
+		o.BusyTimeout = DefaultBusyTimeout
+	}
+	if o.Cache == "" {
+		o.Cache = DefaultCache
+	}
+	// Check link for avoiding db lock errors: https://github.com/mattn/go-sqlite3?tab=readme-ov-file#faq
+	return fmt.Sprintf("file:%s?cache=%s&_journal_mode=%s&_busy_timeout=%d",
+		escapePath(o.Path), o.Cache, o.JournalMode, o.BusyTimeout.Milliseconds())
+}
+
+// escapePath escapes the names of a file path for a file: URI, so that
+// names containing ? or # don't end the path; sqlite decodes the escapes
+func escapePath(path string) string {
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+	return strings.Join(names, "/")
+}
+
+// Open opens and pings the database without creating or upgrading tables
+func Open(opts Options) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", opts.dsn())
+	if err != nil {
+		return nil, err
+	}
+	// ping database to verify connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
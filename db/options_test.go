@@ -0,0 +1,43 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_dsn(t *testing.T) {
+	assert.Equal(t, "file:media.db?cache=shared&_journal_mode=WAL&_busy_timeout=5000", Options{}.dsn())
+	assert.Equal(t, "file:/srv/a.db?cache=private&_journal_mode=DELETE&_busy_timeout=100",
+		Options{Path: "/srv/a.db", JournalMode: "DELETE", BusyTimeout: 100 * time.Millisecond, Cache: "private"}.dsn())
+	assert.Equal(t, "file:x.db?mode=ro", PathOrDSN("file:x.db?mode=ro").dsn())
+	assert.Equal(t, Options{Path: "x.db"}, PathOrDSN("x.db"))
+	assert.Equal(t, "file:/srv/a%3Fb%23c%25d%20e.db?cache=shared&_journal_mode=WAL&_busy_timeout=5000",
+		PathOrDSN("/srv/a?b#c%d e.db").dsn())
+}
+
+func TestOpen(t *testing.T) {
+	db, err := Open(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var mode string
+	assert.NoError(t, db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	// the file is created under its name, not cut at the ? or #
+	dir := t.TempDir()
+	db, err = Open(PathOrDSN(filepath.Join(dir, "a?b#c%d.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE TABLE t (x)")
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "a?b#c%d.db"))
+	assert.NoError(t, err)
+}
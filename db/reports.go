@@ -0,0 +1,111 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// date of a photo: exif original date when known, modification time
+// otherwise; dates are stored as text starting with YYYY-MM-DD
+const mediaDate = `CASE WHEN exif_datetime_original >= '1900-01-01' THEN exif_datetime_original ELSE datetime END`
+
+// currentMedia holds the media rows seen by the latest scan whose roots
+// cover them; rows of files that a later scan didn't find are left out even
+// if they weren't pruned. Rows written without a scan, and rows outside the
+// roots of every scan, are kept.
+const currentMedia = `WITH current_media AS (SELECT * FROM media m WHERE m.scan_id IS NULL
+	OR m.scan_id >= coalesce((SELECT max(s.id) FROM scans s, json_each(s.roots) r
+		WHERE m.path = rtrim(r.value, '/') OR substr(m.path, 1, length(rtrim(r.value, '/')) + 1) = rtrim(r.value, '/') || '/'), 0))
+	`
+
+// Report is a canned query over the media rows of the latest scans
+type Report struct {
+	Name        string
+	Description string
+	Query       string // a ? placeholder, if any, is bound to the row limit
+}
+
+// Reports are the built-in reports
+var Reports = []Report{
+	{"largest", "largest files",
+		currentMedia + `SELECT path, size, mime_type, datetime FROM current_media ORDER BY size DESC LIMIT ?`},
+	{"duplicates", "files with identical content, most wasted space first",
+		currentMedia + `SELECT g.hash, g.size, count(m.path) AS files, g.size * (count(m.path) - 1) AS wasted,
+		group_concat(m.path, ' | ') AS paths
+		FROM content_groups g JOIN current_media m ON m.group_id = g.id
+		GROUP BY g.id HAVING files > 1 ORDER BY wasted DESC LIMIT ?`},
+	{"by-year", "files per year by exif date, or modification time if unknown",
+		currentMedia + `SELECT substr(` + mediaDate + `, 1, 4) AS year, count(*) AS files, sum(size) AS bytes
+		FROM current_media GROUP BY year ORDER BY year`},
+	{"by-month", "files per month by exif date, or modification time if unknown",
+		currentMedia + `SELECT substr(` + mediaDate + `, 1, 7) AS month, count(*) AS files, sum(size) AS bytes
+		FROM current_media GROUP BY month ORDER BY month`},
+	{"by-camera", "images per camera make and model",
+		currentMedia + `SELECT coalesce(nullif(trim(json_extract(exif_json, '$.Make') || ' ' || json_extract(exif_json, '$.Model')), ''), 'unknown') AS camera,
+		count(*) AS files, sum(size) AS bytes
+		FROM current_media WHERE mime_type = 'image' GROUP BY camera ORDER BY files DESC`},
+	{"by-type", "files per mime type",
+		currentMedia + `SELECT mime_type, mime_subtype, count(*) AS files, sum(size) AS bytes
+		FROM current_media GROUP BY mime_type, mime_subtype ORDER BY bytes DESC`},
+	{"size-mismatch", "files sharing a name with a file of a different size",
+		currentMedia + `SELECT name, path, size, datetime FROM current_media WHERE file_size_mismatch = 1
+		ORDER BY name, size DESC LIMIT ?`},
+}
+
+// ReportResult holds the rows of a report; values are int64, float64,
+// string or nil
+type ReportResult struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// FindReport returns the built-in report with the specified name
+func FindReport(name string) (Report, bool) {
+	for _, r := range Reports {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Report{}, false
+}
+
+// RunReport runs a report; limit bounds the number of rows of reports that
+// list files
+func (d *DBImpl) RunReport(r Report, limit int) (*ReportResult, error) {
+	var args []any
+	if strings.Contains(r.Query, "?") {
+		args = append(args, limit)
+	}
+	rows, err := d.media.Query(r.Query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("report %s: %w", r.Name, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	res := &ReportResult{Columns: cols}
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.Format(time.RFC3339)
+			}
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajoyka/fdu/fastdu"
+	"github.com/h2non/filetype/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBImpl_RunReport(t *testing.T) {
+	d, err := New(Options{Path: filepath.Join(t.TempDir(), "media.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	meta := func(path string, size int64, taken time.Time, model string) *fastdu.Meta {
+		m := &fastdu.Meta{Name: filepath.Base(path), Path: path, Size: size, Type: types.NewType("jpg", "image/jpeg"),
+			Modtime: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), DateTimeOriginal: taken}
+		m.Exif.Model = model
+		m.Dups = []fastdu.Duplicate{{Name: path, Size: size}}
+		return m
+	}
+	a := meta("/p/a.jpg", 300, time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC), "EOS")
+	b := meta("/p/b.jpg", 100, time.Time{}, "")
+	c := meta("/q/a.jpg", 200, time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC), "EOS")
+	a.FileSizeMismatch, c.FileSizeMismatch = true, true
+	d.WriteMeta(map[string]*fastdu.Meta{a.Path: a, b.Path: b, c.Path: c})
+
+	run := func(name string, limit int) *ReportResult {
+		r, ok := FindReport(name)
+		if !ok {
+			t.Fatalf("no report %s", name)
+		}
+		res, err := d.RunReport(r, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := run("largest", 2)
+	assert.Equal(t, []string{"path", "size", "mime_type", "datetime"}, res.Columns)
+	if assert.Len(t, res.Rows, 2) {
+		assert.Equal(t, "/p/a.jpg", res.Rows[0][0])
+		assert.Equal(t, int64(300), res.Rows[0][1])
+	}
+
+	// b has no exif date and is counted by its modification time
+	res = run("by-year", 0)
+	assert.Equal(t, [][]any{{"2019", int64(2), int64(500)}, {"2024", int64(1), int64(100)}}, res.Rows)
+	res = run("by-camera", 0)
+	assert.Equal(t, [][]any{{"EOS", int64(2), int64(500)}, {"unknown", int64(1), int64(100)}}, res.Rows)
+	res = run("size-mismatch", 10)
+	assert.Len(t, res.Rows, 2)
+
+	for _, r := range Reports {
+		_, err := d.RunReport(r, 10)
+		assert.NoError(t, err, r.Name)
+	}
+
+	// rows that the latest scan of their root didn't see are left out, also
+	// when they weren't pruned; rows outside of the scanned roots are kept
+	first, err := d.WriteScan(Scan{Roots: []string{"/p/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(map[string]*fastdu.Meta{a.Path: a, b.Path: b})
+	if _, err := d.WriteScan(Scan{Roots: []string{"/p"}}); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteMeta(map[string]*fastdu.Meta{a.Path: a, b.Path: b})
+	if _, err := d.(*DBImpl).media.Exec(`UPDATE media SET scan_id = ? WHERE path = ?`, first, b.Path); err != nil {
+		t.Fatal(err)
+	}
+	res = run("largest", 10)
+	var paths []any
+	for _, row := range res.Rows {
+		paths = append(paths, row[0])
+	}
+	assert.Equal(t, []any{"/p/a.jpg", "/q/a.jpg"}, paths)
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+const (
+	insertScan = `INSERT INTO scans
+	(roots, start_time, end_time, flags, files, bytes, disk_bytes, counters)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	insertScanFile = `INSERT OR REPLACE INTO scan_files
+	(scan_id, filepath, size, modtime, hash, media)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	selectScanFiles = `SELECT filepath, size, modtime, hash, coalesce(media, 1) FROM scan_files WHERE scan_id = ?
+	ORDER BY filepath`
+
+	selectScans = `SELECT id, roots, start_time, end_time, flags, files, bytes, disk_bytes, counters FROM scans`
+
+	// media files seen by the previous scan of the same roots but not by this
+	// one
+	selectDisappeared = `SELECT filepath FROM scan_files
+	WHERE scan_id = (SELECT max(id) FROM scans WHERE roots = (SELECT roots FROM scans WHERE id = ?) AND id < ?)
+	AND coalesce(media, 1) = 1
+	AND filepath NOT IN (SELECT filepath FROM scan_files WHERE scan_id = ?)
+	ORDER BY filepath`
+)
+
+// Scan describes a scan session; rows written after WriteScan are linked to
+// the scan
+type Scan struct {
+	ID        int64
+	Roots     []string
+	Start     time.Time
+	End       time.Time
+	Flags     string
+	Files     int64
+	Bytes     int64
+	DiskBytes int64
+	Counters  fastdu.CountersSnapshot
+}
+
+// WriteScan records a scan session; media and duplicate rows written
+// afterwards are linked to it and marked as seen at the start of the scan
+func (d *DBImpl) WriteScan(scan Scan) (int64, error) {
+	roots, _ := json.Marshal(scan.Roots)
+	counters, _ := json.Marshal(scan.Counters)
+	result, err := d.media.Exec(insertScan, string(roots), scan.Start, scan.End, scan.Flags,
+		scan.Files, scan.Bytes, scan.DiskBytes, string(counters))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	scan.ID = id
+	d.scan = scan
+	return id, nil
+}
+
+// Scans returns the recorded scan sessions, oldest first
+func (d *DBImpl) Scans() ([]Scan, error) {
+	rows, err := d.media.Query(selectScans + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Scan
+	for rows.Next() {
+		var s Scan
+		var roots, counters string
+		if err := rows.Scan(&s.ID, &roots, &s.Start, &s.End, &s.Flags, &s.Files, &s.Bytes,
+			&s.DiskBytes, &counters); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(roots), &s.Roots)
+		json.Unmarshal([]byte(counters), &s.Counters)
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// Disappeared returns the media files seen by the previous scan of the same
+// roots that the specified scan didn't find
+func (d *DBImpl) Disappeared(scanID int64) ([]string, error) {
+	rows, err := d.media.Query(selectDisappeared, scanID, scanID, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		res = append(res, path)
+	}
+	return res, rows.Err()
+}
+
+// ScanFiles returns the files observed by a scan; scans recorded before all
+// files were recorded only have media files
+func (d *DBImpl) ScanFiles(scanID int64) ([]fastdu.FileState, error) {
+	rows, err := d.media.Query(selectScanFiles, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []fastdu.FileState
+	for rows.Next() {
+		var f fastdu.FileState
+		var hash sql.NullString
+		if err := rows.Scan(&f.Path, &f.Size, &f.Modtime, &hash, &f.Media); err != nil {
+			return nil, err
+		}
+		f.Hash = hash.String
+		res = append(res, f)
+	}
+	return res, rows.Err()
+}
+
+// WriteScanFiles records the files observed by the current scan, media and
+// other files
+func (d *DBImpl) WriteScanFiles(files []fastdu.FileState) {
+	if d.scan.ID == 0 {
+		return
+	}
+	tx, err := d.media.Begin()
+	if err != nil {
+		log.Fatalf("scan files begin: %v", err)
+	}
+	stmt, err := tx.Prepare(insertScanFile)
+	if err != nil {
+		log.Fatalf("scan files prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, f := range files {
+		if _, err := stmt.Exec(d.scan.ID, f.Path, f.Size, f.Modtime, f.Hash, f.Media); err != nil {
+			log.Fatalf("insert scan file %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("scan files commit: %v", err)
+	}
+	log.Printf("scan %d files: %d", d.scan.ID, len(files))
+}
+
+// seen returns the id of the current scan, NULL if no scan was recorded,
+// and the time rows written now are marked as seen
+func (d *DBImpl) seen() (sql.NullInt64, time.Time) {
+	if d.scan.ID == 0 {
+		return sql.NullInt64{}, time.Now()
+	}
+	return sql.NullInt64{Int64: d.scan.ID, Valid: true}, d.scan.Start
+}
@@ -0,0 +1,310 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// maxTagSize limits the tags that are read into memory; cover art can make
+// them large
+const maxTagSize = 1 << 20
+
+// id3Info reads the ID3v2 tag at the start of an mp3 file, or the ID3v1 tag
+// at its end. The duration is only known if the tag records it (TLEN).
+func id3Info(r io.ReadSeeker) MediaInfo {
+	var info MediaInfo
+	var hdr [10]byte
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info
+	}
+	if _, err := io.ReadFull(r, hdr[:]); err == nil && string(hdr[:3]) == "ID3" {
+		size := syncsafe(hdr[6:10])
+		if size <= maxTagSize {
+			tag := make([]byte, size)
+			if n, _ := io.ReadFull(r, tag); n > 0 {
+				id3Frames(tag[:n], hdr[3], hdr[5], &info)
+			}
+		}
+	}
+	if info.Tags == (MediaTags{}) {
+		id3v1(r, &info)
+	}
+	return info
+}
+
+// syncsafe decodes the 7 bit bytes of ID3v2 sizes
+func syncsafe(b []byte) int {
+	var n int
+	for _, c := range b {
+		n = n<<7 | int(c&0x7f)
+	}
+	return n
+}
+
+// id3Frames reads the text frames of an ID3v2.2, 2.3 or 2.4 tag
+func id3Frames(tag []byte, version, flags byte, info *MediaInfo) {
+	if flags&0x40 != 0 && len(tag) >= 4 { // extended header
+		n := syncsafe(tag[:4])
+		if version == 3 {
+			n = int(binary.BigEndian.Uint32(tag)) + 4
+		}
+		if n > len(tag) {
+			return
+		}
+		tag = tag[n:]
+	}
+	idLen, hdrLen := 4, 10
+	if version == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(tag) >= hdrLen && tag[0] != 0 { // padding follows the frames
+		id := string(tag[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(tag[4:]))
+		default:
+			size = syncsafe(tag[4:8])
+		}
+		if size < 0 || size > len(tag)-hdrLen {
+			return
+		}
+		body := tag[hdrLen : hdrLen+size]
+		tag = tag[hdrLen+size:]
+		if id[0] != 'T' || len(body) == 0 {
+			continue
+		}
+		text := id3Text(body)
+		switch id {
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(strings.TrimSpace(text)); err == nil && info.Duration == 0 {
+				info.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "TCON", "TCO":
+			// genres may be given as (n) ID3v1 genre numbers before their name
+			if i := strings.IndexByte(text, ')'); strings.HasPrefix(text, "(") && i < len(text)-1 {
+				text = text[i+1:]
+			}
+			info.Tags.set(id, text)
+		default:
+			info.Tags.set(id, text)
+		}
+	}
+}
+
+// id3Text decodes the first value of a text frame, which starts with the
+// encoding of the text
+func id3Text(b []byte) string {
+	enc, b := b[0], b[1:]
+	switch enc {
+	case 1, 2: // UTF-16 with byte order mark, UTF-16BE
+		var order binary.ByteOrder = binary.BigEndian
+		if len(b) >= 2 && enc == 1 {
+			if b[0] == 0xff && b[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			b = b[2:]
+		}
+		var u []uint16
+		for ; len(b) >= 2; b = b[2:] {
+			c := order.Uint16(b)
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		return string(utf16.Decode(u))
+	case 3: // UTF-8
+		s, _, _ := strings.Cut(string(b), "\x00")
+		return s
+	}
+	s, _, _ := bytes.Cut(b, []byte{0})
+	return latin1(s)
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// id3v1 reads the fixed size tag at the end of old mp3 files
+func id3v1(r io.ReadSeeker, info *MediaInfo) {
+	var tag [128]byte
+	if _, err := r.Seek(-128, io.SeekEnd); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, tag[:]); err != nil || string(tag[:3]) != "TAG" {
+		return
+	}
+	field := func(b []byte) string {
+		s, _, _ := bytes.Cut(b, []byte{0})
+		return latin1(s)
+	}
+	info.Tags.set("title", field(tag[3:33]))
+	info.Tags.set("artist", field(tag[33:63]))
+	info.Tags.set("album", field(tag[63:93]))
+	info.Tags.set("year", field(tag[93:97]))
+}
+
+// vorbisComments reads the NAME=value comments of FLAC, Vorbis and Opus
+// files, such as TITLE, ARTIST and DATE
+func vorbisComments(b []byte, info *MediaInfo) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		if name, value, ok := strings.Cut(string(c), "="); ok {
+			info.Tags.set(name, value)
+		}
+	}
+}
+
+// flacInfo reads the stream info (duration) and the comments of a FLAC file
+func flacInfo(r io.ReadSeeker) MediaInfo {
+	var info MediaInfo
+	off := int64(4) // fLaC
+	for last := false; !last; {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return info
+		}
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return info
+		}
+		last = hdr[0]&0x80 != 0
+		typ, size := hdr[0]&0x7f, int64(hdr[1])<<16|int64(hdr[2])<<8|int64(hdr[3])
+		off += 4 + size
+		if (typ != 0 && typ != 4) || size > maxTagSize {
+			continue
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return info
+		}
+		switch typ {
+		case 0: // stream info
+			if len(b) < 18 {
+				continue
+			}
+			rate := uint64(b[10])<<12 | uint64(b[11])<<4 | uint64(b[12])>>4
+			samples := uint64(b[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(b[14:]))
+			if rate > 0 {
+				info.Duration = time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+			}
+		case 4:
+			vorbisComments(b, &info)
+		}
+	}
+	return info
+}
+
+// oggPages returns the payload of the pages of the first logical stream in
+// b and its serial number
+func oggPages(b []byte) ([]byte, uint32) {
+	var payload []byte
+	var serial uint32
+	for first := true; len(b) >= 27 && string(b[:4]) == "OggS"; first = false {
+		segs := int(b[26])
+		if len(b) < 27+segs {
+			break
+		}
+		n := 0
+		for _, s := range b[27 : 27+segs] {
+			n += int(s)
+		}
+		if len(b) < 27+segs+n {
+			n = len(b) - 27 - segs
+		}
+		if s := binary.LittleEndian.Uint32(b[14:]); first || s == serial {
+			serial = s
+			payload = append(payload, b[27+segs:27+segs+n]...)
+		}
+		b = b[27+segs+n:]
+	}
+	return payload, serial
+}
+
+// oggInfo reads the headers of an Ogg Vorbis or Opus file; the duration is
+// the granule position of the last page
+func oggInfo(r io.ReadSeeker) MediaInfo {
+	var info MediaInfo
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info
+	}
+	head, err := io.ReadAll(io.LimitReader(r, maxTagSize))
+	if err != nil {
+		return info
+	}
+	payload, serial := oggPages(head)
+
+	var rate, preSkip int64
+	switch {
+	case bytes.HasPrefix(payload, []byte("\x01vorbis")) && len(payload) >= 16:
+		rate = int64(binary.LittleEndian.Uint32(payload[12:]))
+		if i := bytes.Index(payload, []byte("\x03vorbis")); i >= 0 {
+			vorbisComments(payload[i+7:], &info)
+		}
+	case bytes.HasPrefix(payload, []byte("OpusHead")) && len(payload) >= 12:
+		// granule positions count 48 kHz samples whatever the input rate
+		rate, preSkip = 48000, int64(binary.LittleEndian.Uint16(payload[10:]))
+		if i := bytes.Index(payload, []byte("OpusTags")); i >= 0 {
+			vorbisComments(payload[i+8:], &info)
+		}
+	default:
+		return info
+	}
+
+	// the last page of the stream has the number of samples
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil || rate <= 0 {
+		return info
+	}
+	start := max(end-65536, 0)
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return info
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return info
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if p := tail[i:]; len(p) >= 27 && binary.LittleEndian.Uint32(p[14:]) == serial {
+			if samples := int64(binary.LittleEndian.Uint64(p[6:])) - preSkip; samples > 0 {
+				info.Duration = time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+			}
+			break
+		}
+	}
+	return info
+}
@@ -0,0 +1,111 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// frame returns an ID3v2.3 text frame
+func frame(id string, text []byte) []byte {
+	b := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(text)))...)
+	return append(append(b, 0, 0), text...)
+}
+
+// comments returns Vorbis comments
+func comments(c ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 3)
+	b = append(b, "fdu"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+	for _, s := range c {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func TestID3Info(t *testing.T) {
+	// UTF-16 with byte order mark: "Ünd"
+	utf16 := []byte{1, 0xff, 0xfe, 0xdc, 0, 'n', 0, 'd', 0, 0, 0}
+	frames := bytes.Join([][]byte{
+		frame("TIT2", utf16),
+		frame("TPE1", []byte("\x03Ann")),
+		frame("TALB", []byte("\x00Caf\xe9")),
+		frame("TCON", []byte("\x00(17)Rock")),
+		frame("TYER", []byte("\x002019")),
+		frame("TLEN", []byte("\x00215000")),
+	}, nil)
+	frames = append(frames, make([]byte, 20)...) // padding
+	size := len(frames)
+	hdr := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	file := append(append(hdr, frames...), make([]byte, 100)...)
+
+	info := readMediaInfo(bytes.NewReader(file))
+	assert.Equal(t, MediaTags{Title: "Ünd", Artist: "Ann", Album: "Café", Genre: "Rock", Year: 2019}, info.Tags)
+	assert.Equal(t, 215*time.Second, info.Duration)
+
+	// ID3v1 tags at the end of the file
+	v1 := make([]byte, 128)
+	copy(v1, "TAG")
+	copy(v1[3:], "Old song")
+	copy(v1[33:], "Bob")
+	copy(v1[93:], "1999")
+	file = append([]byte{0xff, 0xfb, 0x90, 0x64}, append(make([]byte, 400), v1...)...)
+	info = readMediaInfo(bytes.NewReader(file))
+	assert.Equal(t, MediaTags{Title: "Old song", Artist: "Bob", Year: 1999}, info.Tags)
+}
+
+func TestFlacInfo(t *testing.T) {
+	// 44.1 kHz, 441000 samples
+	streamInfo := make([]byte, 34)
+	rate, samples := 44100, 441000
+	streamInfo[10], streamInfo[11], streamInfo[12] = byte(rate>>12), byte(rate>>4), byte(rate<<4)
+	binary.BigEndian.PutUint32(streamInfo[14:], uint32(samples))
+	c := comments("TITLE=Song", "artist=Ann", "DATE=2019-03-12")
+	file := []byte("fLaC")
+	file = append(file, 0, 0, 0, 34)
+	file = append(file, streamInfo...)
+	file = append(file, 0x84, 0, byte(len(c)>>8), byte(len(c)))
+	file = append(file, c...)
+
+	info := readMediaInfo(bytes.NewReader(file))
+	assert.Equal(t, 10*time.Second, info.Duration)
+	assert.Equal(t, MediaTags{Title: "Song", Artist: "Ann", Year: 2019}, info.Tags)
+}
+
+// oggPage returns an Ogg page with a single packet
+func oggPage(serial uint32, granule uint64, packet []byte) []byte {
+	b := []byte("OggS\x00\x00")
+	b = binary.LittleEndian.AppendUint64(b, granule)
+	b = binary.LittleEndian.AppendUint32(b, serial)
+	b = append(b, make([]byte, 8)...) // sequence number and checksum
+	var segs []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segs = append(segs, byte(n))
+			break
+		}
+		segs = append(segs, 255)
+	}
+	b = append(b, byte(len(segs)))
+	return append(append(b, segs...), packet...)
+}
+
+func TestOggInfo(t *testing.T) {
+	// Opus at 48 kHz with a pre-skip of 312 samples
+	head := append([]byte("OpusHead\x01\x02"), binary.LittleEndian.AppendUint16(nil, 312)...)
+	head = append(head, make([]byte, 7)...)
+	file := bytes.Join([][]byte{
+		oggPage(7, 0, head),
+		oggPage(7, 0, append([]byte("OpusTags"), comments("TITLE=Song", "GENRE=Jazz")...)),
+		oggPage(7, 48000, make([]byte, 300)),
+		oggPage(7, 48000*5+312, make([]byte, 300)),
+	}, nil)
+	info := readMediaInfo(bytes.NewReader(file))
+	assert.Equal(t, 5*time.Second, info.Duration)
+	assert.Equal(t, MediaTags{Title: "Song", Genre: "Jazz"}, info.Tags)
+}
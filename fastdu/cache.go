@@ -0,0 +1,111 @@
+package fastdu
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/h2non/filetype/types"
+)
+
+// Cache holds the content derived data of files from previous scans so that
+// unchanged files are not opened again
+type Cache interface {
+	// Lookup returns the entry of the file at path if its size, modification
+	// time and inode are unchanged since it was cached
+	Lookup(path string, size int64, modtime time.Time, inode uint64) (CacheEntry, bool)
+}
+
+// CacheEntry is the content derived data of a file; entries are recorded
+// for every file that is sniffed, including files that aren't media
+type CacheEntry struct {
+	Path    string
+	Size    int64
+	Modtime time.Time
+	Inode   uint64
+	Media   bool // image, audio or video file
+	types.Type
+	DateTimeOriginal time.Time
+	CreateDate       time.Time
+	MediaCreated     time.Time
+	Exif             []byte // json encoded exif data
+	MediaInfo        []byte // json encoded MediaInfo of video and audio files, and the ContentID of images
+	Hash             string
+	PHash            string
+}
+
+// fileInfo returns the cached data as returned by getFileInfo; the exif
+// dates are kept separately since exif2 doesn't decode them from json
+func (e CacheEntry) fileInfo() fileInfo {
+	if !e.Media {
+		return fileInfo{}
+	}
+	info := fileInfo{isMedia: true, Type: e.Type, hash: e.Hash, phash: e.PHash,
+		dateTimeOriginal: e.DateTimeOriginal, createDate: e.CreateDate}
+	if len(e.Exif) > 0 {
+		// fields that can't be decoded are left empty
+		_ = json.Unmarshal(e.Exif, &info.exif)
+	}
+	if len(e.MediaInfo) > 0 {
+		_ = json.Unmarshal(e.MediaInfo, &info.media)
+	}
+	info.media.Created = e.MediaCreated
+	return info
+}
+
+// cachedFileInfo returns the cached data of file if it is unchanged
+func (d *DirCount) cachedFileInfo(file string, fInfo os.FileInfo) (fileInfo, bool) {
+	if d.Cache == nil {
+		return fileInfo{}, false
+	}
+	var inode uint64
+	if st, ok := statOf(fInfo); ok {
+		inode = st.ino
+	}
+	e, ok := d.Cache.Lookup(file, fInfo.Size(), fInfo.ModTime(), inode)
+	if !ok {
+		return fileInfo{}, false
+	}
+	// the perceptual hash may not have been requested when it was cached
+	if d.PerceptualHash && e.MIME.Type == "image" && e.PHash == "" {
+		return fileInfo{}, false
+	}
+	d.counts.CacheHitCnt.Add(1)
+	d.countKind(e.MIME.Type)
+	return e.fileInfo(), true
+}
+
+// CacheEntries returns the entries of all files sniffed during the scan,
+// including the content hashes computed by FindDuplicates
+func (d *DirCount) CacheEntries() []CacheEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]CacheEntry, 0, len(d.Meta)+len(d.plain))
+	res = append(res, d.plain...)
+	for _, m := range d.Meta {
+		e := CacheEntry{
+			Path:             m.Path,
+			Size:             m.Size,
+			Modtime:          m.Modtime,
+			Inode:            m.Inode,
+			Media:            true,
+			Type:             m.Type,
+			DateTimeOriginal: m.DateTimeOriginal,
+			CreateDate:       m.CreateDate,
+			MediaCreated:     m.MediaCreated,
+			Hash:             m.Hash,
+			PHash:            m.PHash,
+		}
+		if m.MIME.Type == "image" {
+			e.Exif, _ = json.Marshal(m.Exif)
+			if m.ContentID != "" {
+				e.MediaInfo, _ = json.Marshal(MediaInfo{ContentID: m.ContentID})
+			}
+		} else {
+			e.MediaInfo, _ = json.Marshal(m.mediaInfo())
+		}
+		res = append(res, e)
+	}
+	return res
+}
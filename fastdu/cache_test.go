@@ -0,0 +1,95 @@
+package fastdu
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/h2non/filetype/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapCache is a Cache of entries by path
+type mapCache map[string]CacheEntry
+
+func (c mapCache) Lookup(path string, size int64, modtime time.Time, inode uint64) (CacheEntry, bool) {
+	e, ok := c[path]
+	if !ok || e.Size != size || !e.Modtime.Equal(modtime) || e.Inode != inode {
+		return CacheEntry{}, false
+	}
+	return e, true
+}
+
+func TestScanner_Cache(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a.jpg": []byte("not really a jpeg"),
+		"b.jpg": []byte("something else!!!"),
+		"c.txt": []byte("text"),
+	})
+
+	scan := func(cache Cache) *DirCount {
+		s, err := NewScanner(ScanOptions{Roots: []string{dir}, Cache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Scan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.DirCount.FindDuplicates()
+		return res.DirCount
+	}
+
+	// nothing is media by content, but every sniffed file is recorded
+	d := scan(nil)
+	assert.Empty(t, d.Meta)
+	entries := d.CacheEntries()
+	assert.Len(t, entries, 3)
+
+	// unchanged files are taken from the cache without reading them
+	cache := make(mapCache)
+	jpeg := types.NewType("jpg", "image/jpeg")
+	taken := time.Date(2020, 5, 17, 10, 0, 0, 0, time.UTC)
+	for _, e := range entries {
+		if filepath.Ext(e.Path) == ".jpg" {
+			e.Media, e.Type, e.Hash, e.DateTimeOriginal = true, jpeg, "cafe", taken
+		}
+		cache[e.Path] = e
+	}
+	d = scan(cache)
+	assert.Len(t, d.Meta, 2)
+	m := d.Meta[filepath.Join(dir, "a.jpg")]
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "image", m.MIME.Type)
+		assert.Equal(t, taken, m.DateTimeOriginal)
+		// cached hashes are trusted, so the files are duplicates
+		assert.Equal(t, "cafe", m.Hash)
+		assert.Len(t, m.Dups, 2)
+	}
+	assert.Equal(t, int64(3), d.CountersSnapshot().CacheHitCnt)
+
+	// modified files are read again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.jpg"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	d = scan(cache)
+	assert.Len(t, d.Meta, 1)
+	assert.Equal(t, int64(2), d.CountersSnapshot().CacheHitCnt)
+}
+
+func TestCacheEntry_MediaInfo(t *testing.T) {
+	created := time.Date(2019, 3, 12, 15, 30, 12, 0, time.UTC)
+	m := &Meta{Type: types.NewType("mp4", "video/mp4"), MediaCreated: created, Duration: time.Minute,
+		Width: 1920, Height: 1080, Location: &Location{Latitude: 1, Longitude: 2}, Tags: MediaTags{Title: "Trip"}}
+	b, err := json.Marshal(m.mediaInfo())
+	assert.NoError(t, err)
+
+	e := CacheEntry{Media: true, Type: m.Type, MediaCreated: created, MediaInfo: b}
+	info := e.fileInfo()
+	assert.Equal(t, m.mediaInfo(), info.media)
+}
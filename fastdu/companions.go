@@ -0,0 +1,185 @@
+package fastdu
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CompanionKind is the role of a file that belongs to a photo
+type CompanionKind string
+
+const (
+	CompanionImage   CompanionKind = "image"   // rendered image, e.g. the JPEG of a RAW+JPEG pair
+	CompanionRaw     CompanionKind = "raw"     // raw image
+	CompanionVideo   CompanionKind = "video"   // e.g. the motion of a Live Photo
+	CompanionSidecar CompanionKind = "sidecar" // edits and metadata: xmp, aae, thm, ...
+)
+
+// companion kinds by lower case extension
+var companionExts = map[string]CompanionKind{
+	".jpg": CompanionImage, ".jpeg": CompanionImage, ".heic": CompanionImage, ".heif": CompanionImage,
+	".png": CompanionImage, ".tif": CompanionImage, ".tiff": CompanionImage, ".webp": CompanionImage,
+
+	".dng": CompanionRaw, ".cr2": CompanionRaw, ".cr3": CompanionRaw, ".crw": CompanionRaw,
+	".nef": CompanionRaw, ".nrw": CompanionRaw, ".arw": CompanionRaw, ".srf": CompanionRaw,
+	".sr2": CompanionRaw, ".raf": CompanionRaw, ".orf": CompanionRaw, ".rw2": CompanionRaw,
+	".pef": CompanionRaw, ".srw": CompanionRaw, ".x3f": CompanionRaw, ".3fr": CompanionRaw,
+
+	".mov": CompanionVideo, ".mp4": CompanionVideo, ".m4v": CompanionVideo,
+
+	".xmp": CompanionSidecar, ".aae": CompanionSidecar, ".thm": CompanionSidecar,
+	".pp3": CompanionSidecar, ".dop": CompanionSidecar, ".lrv": CompanionSidecar,
+}
+
+// the primary file of a group is the first of these kinds
+var primaryOrder = []CompanionKind{CompanionImage, CompanionRaw, CompanionVideo}
+
+// Companion is a file that belongs to the primary file of a group
+type Companion struct {
+	Path string
+	Kind CompanionKind
+}
+
+// CompanionGroup is a photo or video with the files that belong to it, such
+// as sidecars, the RAW of a RAW+JPEG pair or the video of a Live Photo
+type CompanionGroup struct {
+	Primary    string
+	Companions []Companion
+}
+
+// companionKey returns the dir and base name of file without its
+// extensions, e.g. dir/IMG_0001 for dir/IMG_0001.JPG and
+// dir/IMG_0001.JPG.xmp, and the kind of the file
+func companionKey(file string) (string, CompanionKind, bool) {
+	ext := strings.ToLower(filepath.Ext(file))
+	kind, ok := companionExts[ext]
+	if !ok {
+		return "", "", false
+	}
+	stem := strings.TrimSuffix(file, filepath.Ext(file))
+	// sidecars named after the full file name: IMG_0001.JPG.xmp
+	if kind == CompanionSidecar {
+		if inner, ok := companionExts[strings.ToLower(filepath.Ext(stem))]; ok && inner != CompanionSidecar {
+			stem = strings.TrimSuffix(stem, filepath.Ext(stem))
+		}
+	}
+	return strings.ToLower(stem), kind, true
+}
+
+// GroupCompanions groups files of the same dir whose names only differ by
+// extension, along with files of the same dir that share a Live Photo content
+// identifier in ids, such as a renamed HEIC and MOV pair; groups without an
+// image, raw or video file are ignored. ids maps file paths to identifiers
+// and may be nil.
+func GroupCompanions(files []string, ids map[string]string) []CompanionGroup {
+	type member struct {
+		path string
+		kind CompanionKind
+	}
+	byKey := make(map[string][]member)
+	for _, f := range files {
+		if key, kind, ok := companionKey(f); ok {
+			byKey[key] = append(byKey[key], member{f, kind})
+		}
+	}
+
+	// names sharing a content identifier are merged into the group of the
+	// first of them
+	joined := make(map[string]string) // key -> key it was merged into
+	root := func(key string) string {
+		for joined[key] != "" {
+			key = joined[key]
+		}
+		return key
+	}
+	first := make(map[[2]string]string) // dir and content identifier -> key
+	for _, f := range files {
+		key, _, ok := companionKey(f)
+		if !ok || ids[f] == "" {
+			continue
+		}
+		id := [2]string{filepath.Dir(f), ids[f]}
+		k, ok := first[id]
+		if !ok {
+			first[id] = key
+			continue
+		}
+		if a, b := root(k), root(key); a != b {
+			joined[b] = a
+		}
+	}
+	for key, members := range byKey {
+		if r := root(key); r != key {
+			byKey[r] = append(byKey[r], members...)
+			delete(byKey, key)
+		}
+	}
+
+	var res []CompanionGroup
+	for _, members := range byKey {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].path < members[j].path })
+		primary := -1
+		for _, kind := range primaryOrder {
+			for i, m := range members {
+				if m.kind == kind {
+					primary = i
+					break
+				}
+			}
+			if primary >= 0 {
+				break
+			}
+		}
+		if primary < 0 {
+			continue
+		}
+		g := CompanionGroup{Primary: members[primary].path}
+		for i, m := range members {
+			if i != primary {
+				g.Companions = append(g.Companions, Companion{m.path, m.kind})
+			}
+		}
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Primary < res[j].Primary })
+	return res
+}
+
+// CompanionGroups groups the files of the scan, including files that aren't
+// media such as sidecars
+func (d *DirCount) CompanionGroups() []CompanionGroup {
+	d.mu.Lock()
+	files := make([]string, 0, len(d.Meta)+len(d.plain))
+	ids := make(map[string]string)
+	for _, m := range d.Meta {
+		files = append(files, m.Path)
+		if m.ContentID != "" {
+			ids[m.Path] = m.ContentID
+		}
+	}
+	for _, e := range d.plain {
+		files = append(files, e.Path)
+	}
+	d.mu.Unlock()
+	return GroupCompanions(files, ids)
+}
+
+// CompanionPath returns the path of a companion that follows its primary
+// from primary to newPrimary: the companion keeps the part of its name after
+// the base name of the primary without extension, e.g. IMG_0001.xmp follows
+// IMG_0001.JPG to 20190312_001.xmp when it is renamed to 20190312_001.jpg.
+// Companions named differently, such as the video of a renamed Live Photo,
+// keep their extension.
+func CompanionPath(companion, primary, newPrimary string) string {
+	stem := filepath.Base(primary)
+	stem = stem[:len(stem)-len(filepath.Ext(stem))]
+	name := filepath.Base(companion)
+	if len(name) < len(stem) || !strings.EqualFold(name[:len(stem)], stem) {
+		name = stem + filepath.Ext(name)
+	}
+	return strings.TrimSuffix(newPrimary, filepath.Ext(newPrimary)) + name[len(stem):]
+}
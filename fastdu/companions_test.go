@@ -0,0 +1,39 @@
+package fastdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCompanions(t *testing.T) {
+	files := []string{
+		"/p/IMG_0001.JPG", "/p/IMG_0001.CR2", "/p/IMG_0001.xmp", "/p/IMG_0001.JPG.xmp",
+		"/p/IMG_0002.HEIC", "/p/IMG_0002.MOV", "/p/IMG_0002.AAE",
+		"/p/IMG_0003.jpg",              // alone
+		"/p/notes.xmp", "/p/notes.txt", // no media file
+		"/q/IMG_0001.CR2", "/q/IMG_0001.xmp", // other dir
+		"/p/2019-03-12 15.30.12.jpg", "/p/2019-03-12 15.31.40.jpg",
+		// a Live Photo renamed apart, and a copy of it in another dir
+		"/p/trip.heic", "/p/trip.aae", "/p/IMG_0004.MOV", "/q/trip.heic",
+	}
+	ids := map[string]string{"/p/trip.heic": "A1B2", "/p/IMG_0004.MOV": "A1B2", "/q/trip.heic": "A1B2",
+		"/p/IMG_0003.jpg": "C3"}
+	groups := GroupCompanions(files, ids)
+	assert.Equal(t, []CompanionGroup{
+		{"/p/IMG_0001.JPG", []Companion{
+			{"/p/IMG_0001.CR2", CompanionRaw}, {"/p/IMG_0001.JPG.xmp", CompanionSidecar}, {"/p/IMG_0001.xmp", CompanionSidecar}}},
+		{"/p/IMG_0002.HEIC", []Companion{{"/p/IMG_0002.AAE", CompanionSidecar}, {"/p/IMG_0002.MOV", CompanionVideo}}},
+		{"/p/trip.heic", []Companion{{"/p/IMG_0004.MOV", CompanionVideo}, {"/p/trip.aae", CompanionSidecar}}},
+		{"/q/IMG_0001.CR2", []Companion{{"/q/IMG_0001.xmp", CompanionSidecar}}},
+	}, groups)
+}
+
+func TestCompanionPath(t *testing.T) {
+	assert.Equal(t, "/a/2019/20190312_001.xmp",
+		CompanionPath("/p/IMG_0001.xmp", "/p/IMG_0001.JPG", "/a/2019/20190312_001.jpg"))
+	assert.Equal(t, "/a/2019/20190312_001.JPG.xmp",
+		CompanionPath("/p/img_0001.JPG.xmp", "/p/IMG_0001.JPG", "/a/2019/20190312_001.jpg"))
+	// the video of a renamed Live Photo
+	assert.Equal(t, "/a/x.MOV", CompanionPath("/p/IMG_0004.MOV", "/p/trip.heic", "/a/x.jpg"))
+}
@@ -0,0 +1,148 @@
+package fastdu
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateSource is where the capture date of a media file comes from
+type DateSource int
+
+const (
+	DateExif     DateSource = iota // exif DateTimeOriginal
+	DateCreate                     // exif CreateDate (DateTimeDigitized)
+	DateVideo                      // creation time of the video container
+	DateFilename                   // date embedded in the file name, e.g. IMG_20190312_153012.jpg
+	DateModtime                    // file modification time
+)
+
+var dateSources = [...]string{"exif", "create", "video", "filename", "mtime"}
+
+func (s DateSource) String() string {
+	if s < 0 || int(s) >= len(dateSources) {
+		return "unknown"
+	}
+	return dateSources[s]
+}
+
+// MarshalText encodes the source by name
+func (s DateSource) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a source name
+func (s *DateSource) UnmarshalText(b []byte) error {
+	i := slices.Index(dateSources[:], string(b))
+	if i < 0 {
+		return fmt.Errorf("unknown date source %q, expecting one of %v", b, dateSources)
+	}
+	*s = DateSource(i)
+	return nil
+}
+
+// DateOrder is the precedence of date sources; it implements flag.Value as
+// a comma separated list
+type DateOrder []DateSource
+
+// DefaultDateOrder prefers the date the picture was taken over dates that
+// change when files are copied
+var DefaultDateOrder = DateOrder{DateExif, DateCreate, DateVideo, DateFilename, DateModtime}
+
+func (o DateOrder) String() string {
+	names := make([]string, len(o))
+	for i, s := range o {
+		names[i] = s.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// Set parses a comma separated list of date sources
+func (o *DateOrder) Set(s string) error {
+	var res DateOrder
+	for _, name := range strings.Split(s, ",") {
+		var src DateSource
+		if err := src.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+			return err
+		}
+		res = append(res, src)
+	}
+	*o = res
+	return nil
+}
+
+// MediaDates are the candidate capture dates of a media file; zero times
+// are unknown
+type MediaDates struct {
+	Name             string // base file name
+	DateTimeOriginal time.Time
+	CreateDate       time.Time
+	MediaCreated     time.Time
+	Modtime          time.Time
+}
+
+// CaptureDate returns the first known date in the order of sources and its
+// source; the modification time is used if none of the sources is known
+func (m MediaDates) CaptureDate(order DateOrder) (time.Time, DateSource) {
+	for _, src := range order {
+		var t time.Time
+		switch src {
+		case DateExif:
+			t = m.DateTimeOriginal
+		case DateCreate:
+			t = m.CreateDate
+		case DateVideo:
+			t = m.MediaCreated
+		case DateFilename:
+			t, _ = DateFromName(m.Name)
+		case DateModtime:
+			t = m.Modtime
+		}
+		// cameras without a clock write zero or 1900 dates
+		if t.Year() > 1900 {
+			return t, src
+		}
+	}
+	return m.Modtime, DateModtime
+}
+
+// file names with a date, optionally followed by a time: IMG_20190312_153012,
+// VID-20190312-WA0001, 2019-03-12 15.30.12, PXL_20210101_123456789
+var nameDate = regexp.MustCompile(`(?:^|\D)((?:19|20)\d\d)[-_.]?(\d\d)[-_.]?(\d\d)(?:[-_ T.]?(\d\d)[-_.:]?(\d\d)[-_.:]?(\d\d))?`)
+
+// DateFromName returns the date embedded in a file name in local time
+func DateFromName(name string) (time.Time, bool) {
+	for _, m := range nameDate.FindAllStringSubmatchIndex(name, -1) {
+		num := func(i int) int {
+			n, _ := strconv.Atoi(name[m[2*i]:m[2*i+1]])
+			return n
+		}
+		year, month, day := num(1), num(2), num(3)
+		dateEnd := m[7]
+		if month < 1 || month > 12 || day < 1 || day > 31 {
+			continue
+		}
+		var hour, min, sec int
+		if m[8] >= 0 {
+			hour, min, sec = num(4), num(5), num(6)
+			if hour <= 23 && min <= 59 && sec <= 59 {
+				dateEnd = m[13]
+			} else {
+				hour, min, sec = 0, 0, 0
+			}
+		}
+		// a date without a time must not be part of a longer number
+		if dateEnd == m[7] && dateEnd < len(name) && name[dateEnd] >= '0' && name[dateEnd] <= '9' {
+			continue
+		}
+		t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+		if t.Day() != day {
+			continue // e.g. February 30
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,67 @@
+package fastdu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"IMG_20190312_153012.jpg", time.Date(2019, 3, 12, 15, 30, 12, 0, time.Local), true},
+		{"VID-20190312-WA0001.mp4", time.Date(2019, 3, 12, 0, 0, 0, 0, time.Local), true},
+		{"2019-03-12 15.30.12.jpg", time.Date(2019, 3, 12, 15, 30, 12, 0, time.Local), true},
+		{"PXL_20210101_123456789.jpg", time.Date(2021, 1, 1, 12, 34, 56, 0, time.Local), true},
+		{"Screenshot_20200229-101500.png", time.Date(2020, 2, 29, 10, 15, 0, 0, time.Local), true},
+		{"IMG_0001.JPG", time.Time{}, false},
+		{"DSC_1234567890.jpg", time.Time{}, false},
+		{"IMG_20190230_000000.jpg", time.Time{}, false}, // no February 30
+		{"120190312.jpg", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DateFromName(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.True(t, tt.want.Equal(got), "got %v", got)
+		})
+	}
+}
+
+func TestMediaDates_CaptureDate(t *testing.T) {
+	exif := time.Date(2010, 5, 1, 10, 0, 0, 0, time.UTC)
+	video := time.Date(2011, 6, 2, 10, 0, 0, 0, time.UTC)
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MediaDates{Name: "IMG_20120703_101010.jpg", DateTimeOriginal: exif, MediaCreated: video, Modtime: mtime}
+
+	date, src := m.CaptureDate(DefaultDateOrder)
+	assert.Equal(t, exif, date)
+	assert.Equal(t, DateExif, src)
+
+	date, src = m.CaptureDate(DateOrder{DateCreate, DateVideo})
+	assert.Equal(t, video, date)
+	assert.Equal(t, DateVideo, src)
+
+	date, src = m.CaptureDate(DateOrder{DateFilename})
+	assert.Equal(t, 2012, date.Year())
+	assert.Equal(t, DateFilename, src)
+
+	// zero exif dates are unknown, and mtime is the last resort
+	m = MediaDates{Name: "a.jpg", DateTimeOriginal: time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), Modtime: mtime}
+	date, src = m.CaptureDate(DateOrder{DateExif})
+	assert.Equal(t, mtime, date)
+	assert.Equal(t, DateModtime, src)
+}
+
+func TestDateOrder_Set(t *testing.T) {
+	var o DateOrder
+	assert.NoError(t, o.Set("filename, mtime"))
+	assert.Equal(t, DateOrder{DateFilename, DateModtime}, o)
+	assert.Equal(t, "filename,mtime", o.String())
+	assert.Error(t, o.Set("exif,ctime"))
+	assert.Equal(t, "exif,create,video,filename,mtime", DefaultDateOrder.String())
+}
@@ -0,0 +1,471 @@
+package fastdu
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KeepPolicy selects the file of a group of duplicates that is kept
+type KeepPolicy int
+
+const (
+	KeepOriginals KeepPolicy = iota // prefer paths containing "Originals" (unedited photos of iPhoto libraries), then the shortest path
+	KeepOldest                      // oldest modification time
+	KeepShortest                    // shortest path
+	KeepRoot                        // a file below DedupeOptions.Root; groups without one are skipped
+)
+
+var keepPolicies = [...]string{"originals", "oldest", "shortest", "root"}
+
+func (p KeepPolicy) String() string {
+	if p < 0 || int(p) >= len(keepPolicies) {
+		return "unknown"
+	}
+	return keepPolicies[p]
+}
+
+// Set parses the policy name; it implements flag.Value
+func (p *KeepPolicy) Set(s string) error {
+	i := slices.Index(keepPolicies[:], s)
+	if i < 0 {
+		return fmt.Errorf("unknown keep policy %q, expecting one of %v", s, keepPolicies)
+	}
+	*p = KeepPolicy(i)
+	return nil
+}
+
+// DedupeAction is what happens to the duplicates that aren't kept
+type DedupeAction int
+
+const (
+	ActionDelete     DedupeAction = iota // remove the file
+	ActionQuarantine                     // move the file below the quarantine dir
+	ActionHardlink                       // replace the file by a hard link to the kept file
+	ActionReflink                        // replace the file by a copy sharing the blocks of the kept file
+)
+
+var dedupeActions = [...]string{"delete", "quarantine", "hardlink", "reflink"}
+
+func (a DedupeAction) String() string {
+	if a < 0 || int(a) >= len(dedupeActions) {
+		return "unknown"
+	}
+	return dedupeActions[a]
+}
+
+// Set parses the action name; it implements flag.Value
+func (a *DedupeAction) Set(s string) error {
+	i := slices.Index(dedupeActions[:], s)
+	if i < 0 {
+		return fmt.Errorf("unknown dedupe action %q, expecting one of %v", s, dedupeActions)
+	}
+	*a = DedupeAction(i)
+	return nil
+}
+
+// MarshalText encodes the action by name in plan files and undo logs
+func (a DedupeAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes an action name
+func (a *DedupeAction) UnmarshalText(b []byte) error {
+	return a.Set(string(b))
+}
+
+// DedupeOptions configures a dedupe plan
+type DedupeOptions struct {
+	Keep       KeepPolicy
+	Root       string // dir whose files are kept with KeepRoot
+	Action     DedupeAction
+	Quarantine string // dir the duplicates are moved to with ActionQuarantine
+
+	// companions of media files by path, such as sidecars; companions of
+	// deleted files are moved next to the kept file and companions of
+	// quarantined files are quarantined with them
+	Companions map[string][]Companion
+}
+
+// DedupeGroup is a group of files with identical content; Keep stays as is
+// and the action is applied to Remove
+type DedupeGroup struct {
+	Hash       string
+	Size       int64
+	Keep       string
+	Remove     []string
+	Companions map[string][]string `json:",omitempty"` // companions of the files of Remove
+}
+
+// DedupePlan lists the actions of a dedupe; plans are written to a file so
+// that they can be reviewed before they are executed
+type DedupePlan struct {
+	Created    time.Time
+	Action     DedupeAction
+	Keep       string
+	Quarantine string `json:",omitempty"`
+	Groups     []DedupeGroup
+	Skipped    []DedupeGroup `json:",omitempty"` // groups without a file to keep
+}
+
+// Savings returns the number of files the plan acts on and their size
+func (p *DedupePlan) Savings() (int, int64) {
+	var files int
+	var size int64
+	for _, g := range p.Groups {
+		files += len(g.Remove)
+		size += g.Size * int64(len(g.Remove))
+	}
+	return files, size
+}
+
+// PlanDedupe chooses the file to keep of every group of files with identical
+// content; files of a group are expected to share Hash and Size
+func PlanDedupe(groups [][]FileState, opts DedupeOptions) (*DedupePlan, error) {
+	if opts.Action == ActionQuarantine && opts.Quarantine == "" {
+		return nil, errors.New("quarantine action requires a quarantine dir")
+	}
+	if opts.Keep == KeepRoot && opts.Root == "" {
+		return nil, errors.New("root keep policy requires a root dir")
+	}
+	plan := &DedupePlan{Created: time.Now(), Action: opts.Action, Keep: opts.Keep.String(), Quarantine: opts.Quarantine}
+	if opts.Keep == KeepRoot {
+		plan.Keep += ":" + opts.Root
+	}
+
+	for _, files := range groups {
+		if len(files) < 2 {
+			continue
+		}
+		files = slices.Clone(files)
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		keep, ok := chooseKeep(files, opts)
+		g := DedupeGroup{Hash: files[0].Hash, Size: files[0].Size}
+		for i, f := range files {
+			if i == keep {
+				g.Keep = f.Path
+				continue
+			}
+			g.Remove = append(g.Remove, f.Path)
+			for _, c := range opts.Companions[f.Path] {
+				if g.Companions == nil {
+					g.Companions = make(map[string][]string)
+				}
+				g.Companions[f.Path] = append(g.Companions[f.Path], c.Path)
+			}
+		}
+		if !ok {
+			g.Keep, g.Remove, g.Companions = "", nil, nil
+			for _, f := range files {
+				g.Remove = append(g.Remove, f.Path)
+			}
+			plan.Skipped = append(plan.Skipped, g)
+			continue
+		}
+		plan.Groups = append(plan.Groups, g)
+	}
+	// largest savings first
+	sort.SliceStable(plan.Groups, func(i, j int) bool {
+		gi, gj := plan.Groups[i], plan.Groups[j]
+		return gi.Size*int64(len(gi.Remove)) > gj.Size*int64(len(gj.Remove))
+	})
+	return plan, nil
+}
+
+// chooseKeep returns the index of the file to keep of files sorted by path
+func chooseKeep(files []FileState, opts DedupeOptions) (int, bool) {
+	shortest := func(candidates []int) int {
+		best := candidates[0]
+		for _, i := range candidates[1:] {
+			if len(files[i].Path) < len(files[best].Path) {
+				best = i
+			}
+		}
+		return best
+	}
+	all := make([]int, len(files))
+	for i := range files {
+		all[i] = i
+	}
+
+	switch opts.Keep {
+	case KeepOriginals:
+		var originals []int
+		for i, f := range files {
+			if strings.Contains(f.Path, "Originals") {
+				originals = append(originals, i)
+			}
+		}
+		if len(originals) > 0 {
+			return shortest(originals), true
+		}
+		return shortest(all), true
+	case KeepOldest:
+		best := 0
+		for i, f := range files {
+			if f.Modtime.Before(files[best].Modtime) {
+				best = i
+			}
+		}
+		return best, true
+	case KeepRoot:
+		root := filepath.Clean(opts.Root) + string(filepath.Separator)
+		var below []int
+		for i, f := range files {
+			if strings.HasPrefix(f.Path, root) {
+				below = append(below, i)
+			}
+		}
+		if len(below) == 0 {
+			return 0, false
+		}
+		return shortest(below), true
+	}
+	return shortest(all), true
+}
+
+// UndoRecord is a line of the undo log written while a plan is executed
+type UndoRecord struct {
+	Action    DedupeAction
+	Path      string      // file that was acted on
+	Keep      string      // kept file with the same content
+	Moved     string      `json:",omitempty"` // quarantine location, or new location of a companion
+	Hash      string      // content hash of both files
+	Mode      os.FileMode // permissions of the file before the action
+	Modtime   time.Time
+	Companion bool `json:",omitempty"` // Path is a companion of a file that was acted on and was moved
+}
+
+// DedupeResult summarizes the execution of a plan
+type DedupeResult struct {
+	Done    int
+	Bytes   int64 // size of the files acted on
+	Skipped int   // files that changed since the plan was made or are already linked
+	Errors  []error
+}
+
+// Execute applies the plan. Before a file is touched, it and the kept file
+// are hashed again, and files whose content no longer matches the plan are
+// skipped. Every action is appended to the undo log as a json line before
+// it is taken, so that the log covers the files touched by a run that
+// crashed; undoing an action that failed reports that its file exists.
+// Execution stops if the undo log can't be written.
+func (p *DedupePlan) Execute(undo io.Writer) *DedupeResult {
+	res := &DedupeResult{}
+	enc := json.NewEncoder(undo)
+	for _, g := range p.Groups {
+		if sum, err := HashFile(g.Keep); err != nil || sum != g.Hash {
+			res.Skipped += len(g.Remove)
+			res.Errors = append(res.Errors, fmt.Errorf("%s: kept file changed or missing, group skipped", g.Keep))
+			continue
+		}
+		keepInfo, err := os.Stat(g.Keep)
+		if err != nil {
+			res.Errors = append(res.Errors, err)
+			continue
+		}
+
+		for _, path := range g.Remove {
+			fInfo, err := os.Lstat(path)
+			if err != nil {
+				res.Errors = append(res.Errors, err)
+				continue
+			}
+			if !fInfo.Mode().IsRegular() || fInfo.Size() != g.Size {
+				res.Skipped++
+				continue
+			}
+			if os.SameFile(fInfo, keepInfo) {
+				// already a hard link of the kept file
+				res.Skipped++
+				continue
+			}
+			if sum, err := HashFile(path); err != nil || sum != g.Hash {
+				res.Skipped++
+				continue
+			}
+
+			rec := UndoRecord{Action: p.Action, Path: path, Keep: g.Keep, Hash: g.Hash,
+				Mode: fInfo.Mode().Perm(), Modtime: fInfo.ModTime()}
+			if err := p.prepare(&rec); err != nil {
+				res.Errors = append(res.Errors, fmt.Errorf("%s %s: %w", p.Action, path, err))
+				continue
+			}
+			if err := writeUndo(enc, undo, rec); err != nil {
+				res.Errors = append(res.Errors, fmt.Errorf("undo log: %w; stopped before %s", err, path))
+				return res
+			}
+			if err := p.apply(rec); err != nil {
+				res.Errors = append(res.Errors, fmt.Errorf("%s %s: %w", p.Action, path, err))
+				continue
+			}
+			res.Done++
+			res.Bytes += g.Size
+
+			for _, c := range g.Companions[path] {
+				crec, err := p.companionRecord(c, path, g.Keep)
+				if err != nil {
+					res.Errors = append(res.Errors, fmt.Errorf("companion %s: %w", c, err))
+					continue
+				}
+				if crec == nil {
+					continue
+				}
+				if err := writeUndo(enc, undo, *crec); err != nil {
+					res.Errors = append(res.Errors, fmt.Errorf("undo log: %w; stopped before %s", err, c))
+					return res
+				}
+				if err := MoveFile(crec.Path, crec.Moved); err != nil {
+					res.Errors = append(res.Errors, fmt.Errorf("companion %s: %w", c, err))
+				}
+			}
+		}
+	}
+	return res
+}
+
+// writeUndo appends rec to the undo log, and syncs the log if it is a file
+// so that the record survives a crash during the action
+func writeUndo(enc *json.Encoder, undo io.Writer, rec UndoRecord) error {
+	if err := enc.Encode(rec); err != nil {
+		return err
+	}
+	if f, ok := undo.(interface{ Sync() error }); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+// prepare sets the quarantine location of the file of rec and creates its
+// dir
+func (p *DedupePlan) prepare(rec *UndoRecord) error {
+	if p.Action != ActionQuarantine {
+		return nil
+	}
+	abs, err := filepath.Abs(rec.Path)
+	if err != nil {
+		return err
+	}
+	rec.Moved = filepath.Join(p.Quarantine, abs)
+	return os.MkdirAll(filepath.Dir(rec.Moved), 0755)
+}
+
+func (p *DedupePlan) apply(rec UndoRecord) error {
+	switch p.Action {
+	case ActionDelete:
+		return os.Remove(rec.Path)
+	case ActionQuarantine:
+		return MoveFile(rec.Path, rec.Moved)
+	case ActionHardlink:
+		return replaceWith(rec.Path, func(tmp string) error { return os.Link(rec.Keep, tmp) })
+	case ActionReflink:
+		return replaceWith(rec.Path, func(tmp string) error {
+			if err := Reflink(rec.Keep, tmp); err != nil {
+				return err
+			}
+			if err := os.Chmod(tmp, rec.Mode); err != nil {
+				return err
+			}
+			return os.Chtimes(tmp, rec.Modtime, rec.Modtime)
+		})
+	}
+	return fmt.Errorf("unknown action %d", p.Action)
+}
+
+// companionRecord returns the undo record of moving a companion of path:
+// along with path to the quarantine, or next to the kept file when path was
+// deleted. Links replace path in place, so its companions stay, and nil is
+// returned.
+func (p *DedupePlan) companionRecord(companion, path, keep string) (*UndoRecord, error) {
+	var to string
+	switch p.Action {
+	case ActionQuarantine:
+		abs, err := filepath.Abs(companion)
+		if err != nil {
+			return nil, err
+		}
+		to = filepath.Join(p.Quarantine, abs)
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return nil, err
+		}
+	case ActionDelete:
+		to = CompanionPath(companion, path, keep)
+	default:
+		return nil, nil
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return nil, fmt.Errorf("left in place, %s exists", to)
+	}
+	return &UndoRecord{Action: p.Action, Path: companion, Keep: keep, Moved: to, Companion: true}, nil
+}
+
+// UndoDedupe reverts the actions of an undo log, last action first. Deleted
+// and linked files are restored as independent copies of the kept file,
+// which must still have the same content; quarantined files and companions
+// are moved back.
+func UndoDedupe(log io.Reader) (int, []error) {
+	var recs []UndoRecord
+	scanner := bufio.NewScanner(log)
+	for scanner.Scan() {
+		var rec UndoRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return 0, []error{fmt.Errorf("undo log: %w", err)}
+		}
+		recs = append(recs, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, []error{err}
+	}
+
+	var done int
+	var errs []error
+	for i := len(recs) - 1; i >= 0; i-- {
+		rec := recs[i]
+		if err := undoRecord(rec); err != nil {
+			errs = append(errs, fmt.Errorf("undo %s %s: %w", rec.Action, rec.Path, err))
+			continue
+		}
+		done++
+	}
+	return done, errs
+}
+
+func undoRecord(rec UndoRecord) error {
+	if rec.Action == ActionQuarantine || rec.Companion {
+		if _, err := os.Lstat(rec.Path); err == nil {
+			return fmt.Errorf("%s exists", rec.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(rec.Path), 0755); err != nil {
+			return err
+		}
+		return MoveFile(rec.Moved, rec.Path)
+	}
+
+	if sum, err := HashFile(rec.Keep); err != nil || sum != rec.Hash {
+		return fmt.Errorf("kept file %s changed or missing", rec.Keep)
+	}
+	restore := func(tmp string) error {
+		if err := CopyFile(rec.Keep, tmp); err != nil {
+			return err
+		}
+		os.Chmod(tmp, rec.Mode)
+		return os.Chtimes(tmp, rec.Modtime, rec.Modtime)
+	}
+	if rec.Action == ActionDelete {
+		if _, err := os.Lstat(rec.Path); err == nil {
+			return fmt.Errorf("%s exists", rec.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(rec.Path), 0755); err != nil {
+			return err
+		}
+	}
+	return replaceWith(rec.Path, restore)
+}
@@ -0,0 +1,225 @@
+package fastdu
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanDedupe(t *testing.T) {
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.AddDate(1, 0, 0)
+	group := []FileState{
+		{"/photos/2020/IMG_1.JPG", 10, t2, "h", true},
+		{"/lib/Masters/Originals/IMG_1.JPG", 10, t2, "h", true},
+		{"/backup/a/b/c/IMG_1.JPG", 10, t1, "h", true},
+		{"/b/IMG_1.JPG", 10, t2, "h", true},
+	}
+	tests := []struct {
+		opts DedupeOptions
+		keep string
+	}{
+		{DedupeOptions{Keep: KeepOriginals}, "/lib/Masters/Originals/IMG_1.JPG"},
+		{DedupeOptions{Keep: KeepOldest}, "/backup/a/b/c/IMG_1.JPG"},
+		{DedupeOptions{Keep: KeepShortest}, "/b/IMG_1.JPG"},
+		{DedupeOptions{Keep: KeepRoot, Root: "/photos"}, "/photos/2020/IMG_1.JPG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.opts.Keep.String(), func(t *testing.T) {
+			plan, err := PlanDedupe([][]FileState{group}, tt.opts)
+			if assert.NoError(t, err) && assert.Len(t, plan.Groups, 1) {
+				assert.Equal(t, tt.keep, plan.Groups[0].Keep)
+				assert.Len(t, plan.Groups[0].Remove, 3)
+				assert.NotContains(t, plan.Groups[0].Remove, tt.keep)
+			}
+			files, size := plan.Savings()
+			assert.Equal(t, 3, files)
+			assert.Equal(t, int64(30), size)
+		})
+	}
+
+	plan, err := PlanDedupe([][]FileState{group}, DedupeOptions{Keep: KeepRoot, Root: "/none"})
+	if assert.NoError(t, err) {
+		assert.Empty(t, plan.Groups)
+		assert.Len(t, plan.Skipped, 1)
+	}
+	_, err = PlanDedupe(nil, DedupeOptions{Action: ActionQuarantine})
+	assert.Error(t, err)
+}
+
+// dedupeFixture creates a kept file and two copies, and plans the action
+func dedupeFixture(t *testing.T, action DedupeAction) (*DedupePlan, string) {
+	dir := t.TempDir()
+	var group []FileState
+	for _, name := range []string{"a/keep.jpg", "b/copy.jpg", "c/copy.jpg"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("same content"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		hash, _ := HashFile(path)
+		group = append(group, FileState{Path: path, Size: 12, Hash: hash})
+	}
+	plan, err := PlanDedupe([][]FileState{group},
+		DedupeOptions{Keep: KeepRoot, Root: filepath.Join(dir, "a"), Action: action, Quarantine: filepath.Join(dir, "q")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan, dir
+}
+
+func TestDedupePlan_Execute(t *testing.T) {
+	for _, action := range []DedupeAction{ActionDelete, ActionQuarantine, ActionHardlink} {
+		t.Run(action.String(), func(t *testing.T) {
+			plan, dir := dedupeFixture(t, action)
+			keep := filepath.Join(dir, "a/keep.jpg")
+			copyB := filepath.Join(dir, "b/copy.jpg")
+			// modified after the plan was made: must be left alone
+			copyC := filepath.Join(dir, "c/copy.jpg")
+			os.WriteFile(copyC, []byte("edited after"), 0640)
+
+			var undoLog bytes.Buffer
+			res := plan.Execute(&undoLog)
+			assert.Equal(t, 1, res.Done)
+			assert.Equal(t, 1, res.Skipped)
+			assert.Empty(t, res.Errors)
+			assert.Equal(t, int64(12), res.Bytes)
+
+			switch action {
+			case ActionDelete:
+				assert.NoFileExists(t, copyB)
+			case ActionQuarantine:
+				assert.NoFileExists(t, copyB)
+				assert.FileExists(t, filepath.Join(dir, "q", copyB))
+			case ActionHardlink:
+				fi1, _ := os.Stat(keep)
+				fi2, _ := os.Stat(copyB)
+				assert.True(t, os.SameFile(fi1, fi2))
+			}
+
+			done, errs := UndoDedupe(&undoLog)
+			assert.Equal(t, 1, done)
+			assert.Empty(t, errs)
+			b, err := os.ReadFile(copyB)
+			assert.NoError(t, err)
+			assert.Equal(t, "same content", string(b))
+			fi1, _ := os.Stat(keep)
+			fi2, _ := os.Stat(copyB)
+			assert.False(t, os.SameFile(fi1, fi2))
+			assert.Equal(t, os.FileMode(0640), fi2.Mode().Perm())
+		})
+	}
+}
+
+func TestDedupePlan_ExecuteCompanions(t *testing.T) {
+	for _, action := range []DedupeAction{ActionDelete, ActionQuarantine} {
+		t.Run(action.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			keep := filepath.Join(dir, "a/IMG_1.jpg")
+			dup := filepath.Join(dir, "b/IMG_0001.JPG")
+			xmp := filepath.Join(dir, "b/IMG_0001.xmp")
+			var group []FileState
+			for _, path := range []string{keep, dup} {
+				os.MkdirAll(filepath.Dir(path), 0755)
+				os.WriteFile(path, []byte("same content"), 0640)
+				hash, _ := HashFile(path)
+				group = append(group, FileState{Path: path, Size: 12, Hash: hash})
+			}
+			os.WriteFile(xmp, []byte("<x:xmpmeta/>"), 0640)
+
+			plan, err := PlanDedupe([][]FileState{group}, DedupeOptions{
+				Keep: KeepRoot, Root: filepath.Join(dir, "a"), Action: action, Quarantine: filepath.Join(dir, "q"),
+				Companions: map[string][]Companion{dup: {{xmp, CompanionSidecar}}},
+			})
+			if !assert.NoError(t, err) || !assert.Len(t, plan.Groups, 1) {
+				return
+			}
+			assert.Equal(t, map[string][]string{dup: {xmp}}, plan.Groups[0].Companions)
+
+			var undoLog bytes.Buffer
+			res := plan.Execute(&undoLog)
+			assert.Equal(t, 1, res.Done)
+			assert.Empty(t, res.Errors)
+			assert.NoFileExists(t, xmp)
+			if action == ActionDelete {
+				assert.FileExists(t, filepath.Join(dir, "a/IMG_1.xmp"))
+			} else {
+				assert.FileExists(t, filepath.Join(dir, "q", xmp))
+			}
+
+			done, errs := UndoDedupe(&undoLog)
+			assert.Equal(t, 2, done)
+			assert.Empty(t, errs)
+			assert.FileExists(t, dup)
+			assert.FileExists(t, xmp)
+			assert.NoFileExists(t, filepath.Join(dir, "a/IMG_1.xmp"))
+		})
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// TestDedupePlan_ExecuteUndoFails stops before the first action whose undo
+// record can't be written
+func TestDedupePlan_ExecuteUndoFails(t *testing.T) {
+	plan, dir := dedupeFixture(t, ActionDelete)
+	res := plan.Execute(failingWriter{})
+	assert.Zero(t, res.Done)
+	if assert.Len(t, res.Errors, 1) {
+		assert.ErrorContains(t, res.Errors[0], "disk full")
+	}
+	assert.FileExists(t, filepath.Join(dir, "b/copy.jpg"))
+	assert.FileExists(t, filepath.Join(dir, "c/copy.jpg"))
+}
+
+// TestMoveAcross moves a file the way MoveFile does across devices
+func TestMoveAcross(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "a.jpg"), filepath.Join(dir, "q", "a.jpg")
+	mtime := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	os.MkdirAll(filepath.Dir(dst), 0755)
+	if err := os.WriteFile(src, []byte("same content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(src, mtime, mtime)
+
+	assert.NoError(t, moveAcross(src, dst))
+	assert.NoFileExists(t, src)
+	b, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "same content", string(b))
+	fInfo, err := os.Stat(dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0640), fInfo.Mode().Perm())
+		assert.True(t, mtime.Equal(fInfo.ModTime()))
+	}
+
+	// an existing destination is never overwritten and the source is kept
+	os.WriteFile(src, []byte("other content"), 0640)
+	assert.Error(t, moveAcross(src, dst))
+	assert.FileExists(t, src)
+	b, _ = os.ReadFile(dst)
+	assert.Equal(t, "same content", string(b))
+}
+
+func TestDedupeAction_Set(t *testing.T) {
+	var a DedupeAction
+	assert.NoError(t, a.Set("reflink"))
+	assert.Equal(t, ActionReflink, a)
+	assert.Error(t, a.Set("shred"))
+
+	var p KeepPolicy
+	assert.NoError(t, p.Set("oldest"))
+	assert.Equal(t, KeepOldest, p)
+	assert.Error(t, p.Set("newest"))
+}
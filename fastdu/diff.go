@@ -0,0 +1,214 @@
+package fastdu
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// FileState is a file as recorded by a scan
+type FileState struct {
+	Path    string
+	Size    int64
+	Modtime time.Time
+	Hash    string // content hash, empty if unknown
+	Media   bool   // image, audio or video file
+}
+
+// Move is a file that was moved or renamed between two scans
+type Move struct {
+	From FileState
+	To   FileState
+}
+
+// Modification is a file whose size, mtime or content changed between two
+// scans
+type Modification struct {
+	Old FileState
+	New FileState
+}
+
+// DirGrowth is the change of the cumulative size of a dir between two scans
+type DirGrowth struct {
+	Dir     string
+	OldSize int64
+	NewSize int64
+}
+
+// Delta returns the size difference
+func (g DirGrowth) Delta() int64 {
+	return g.NewSize - g.OldSize
+}
+
+// ScanDiff lists the differences between two scans; all lists are sorted by
+// path and Dirs by growth
+type ScanDiff struct {
+	Added    []FileState
+	Removed  []FileState
+	Modified []Modification
+	Moved    []Move
+	Dirs     []DirGrowth // dirs whose cumulative size changed, largest growth first
+}
+
+// FileStates returns the files recorded by the scan, media and other files,
+// so that scans can be compared
+func (d *DirCount) FileStates() []FileState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]FileState, 0, len(d.Meta)+len(d.plain))
+	for _, e := range d.plain {
+		res = append(res, FileState{Path: e.Path, Size: e.Size, Modtime: e.Modtime})
+	}
+	for _, m := range d.Meta {
+		res = append(res, FileState{Path: m.Path, Size: m.Size, Modtime: m.Modtime, Hash: m.Hash, Media: true})
+	}
+	sortByPath(res)
+	return res
+}
+
+// DiffScans compares the files of an older and a newer scan. A removed and
+// an added file are reported as a move when their content hashes match, or
+// when either hash is unknown and size and mtime match.
+func DiffScans(older, newer []FileState) *ScanDiff {
+	oldByPath := make(map[string]FileState, len(older))
+	for _, f := range older {
+		oldByPath[f.Path] = f
+	}
+	newByPath := make(map[string]FileState, len(newer))
+	for _, f := range newer {
+		newByPath[f.Path] = f
+	}
+
+	res := &ScanDiff{}
+	var added, removed []FileState
+	for _, f := range newer {
+		o, ok := oldByPath[f.Path]
+		switch {
+		case !ok:
+			added = append(added, f)
+		case o.Size != f.Size || !o.Modtime.Equal(f.Modtime) || (o.Hash != "" && f.Hash != "" && o.Hash != f.Hash):
+			res.Modified = append(res.Modified, Modification{o, f})
+		}
+	}
+	for _, f := range older {
+		if _, ok := newByPath[f.Path]; !ok {
+			removed = append(removed, f)
+		}
+	}
+	sortByPath(added)
+	sortByPath(removed)
+
+	// pair removed files with added ones that look identical
+	gone := make(map[contentKey][]FileState)
+	goneByHash := make(map[string][]FileState)
+	for _, f := range removed {
+		gone[keyOf(f)] = append(gone[keyOf(f)], f)
+		if f.Hash != "" {
+			goneByHash[f.Hash] = append(goneByHash[f.Hash], f)
+		}
+	}
+	matched := make(map[string]bool)
+	movedFrom := func(f FileState) (FileState, bool) {
+		candidates := gone[keyOf(f)]
+		if f.Hash != "" {
+			candidates = slices.Concat(goneByHash[f.Hash], candidates)
+		}
+		for _, c := range candidates {
+			if !matched[c.Path] && sameContent(c, f) {
+				return c, true
+			}
+		}
+		return FileState{}, false
+	}
+	for _, f := range added {
+		if from, ok := movedFrom(f); ok {
+			res.Moved = append(res.Moved, Move{from, f})
+			matched[from.Path] = true
+			continue
+		}
+		res.Added = append(res.Added, f)
+	}
+	for _, f := range removed {
+		if !matched[f.Path] {
+			res.Removed = append(res.Removed, f)
+		}
+	}
+	sort.Slice(res.Modified, func(i, j int) bool { return res.Modified[i].New.Path < res.Modified[j].New.Path })
+
+	res.Dirs = dirGrowth(older, newer)
+	return res
+}
+
+// contentKey identifies files of the same size and mtime for move detection
+type contentKey struct {
+	size  int64
+	mtime int64
+}
+
+func keyOf(f FileState) contentKey {
+	return contentKey{size: f.Size, mtime: f.Modtime.UnixNano()}
+}
+
+// sameContent reports whether two files look identical: their content hashes
+// match, or when either hash is unknown, their size and mtime
+func sameContent(a, b FileState) bool {
+	if a.Hash != "" && b.Hash != "" {
+		return a.Hash == b.Hash
+	}
+	return keyOf(a) == keyOf(b)
+}
+
+func sortByPath(files []FileState) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+// dirGrowth returns the dirs whose cumulative size changed; sizes are rolled
+// up to every ancestor
+func dirGrowth(older, newer []FileState) []DirGrowth {
+	sizes := make(map[string]*DirGrowth)
+	add := func(files []FileState, newSize bool) {
+		for _, f := range files {
+			dir := filepath.Dir(f.Path)
+			for {
+				g, ok := sizes[dir]
+				if !ok {
+					g = &DirGrowth{Dir: dir}
+					sizes[dir] = g
+				}
+				if newSize {
+					g.NewSize += f.Size
+				} else {
+					g.OldSize += f.Size
+				}
+				parent := filepath.Dir(dir)
+				if parent == dir {
+					break
+				}
+				dir = parent
+			}
+		}
+	}
+	add(older, false)
+	add(newer, true)
+
+	var res []DirGrowth
+	for _, g := range sizes {
+		if g.Delta() != 0 {
+			res = append(res, *g)
+		}
+	}
+	// deeper dirs come first on ties since they locate the change better;
+	// ancestors always have shorter paths
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Delta() != res[j].Delta() {
+			return res[i].Delta() > res[j].Delta()
+		}
+		if len(res[i].Dir) != len(res[j].Dir) {
+			return len(res[i].Dir) > len(res[j].Dir)
+		}
+		return res[i].Dir < res[j].Dir
+	})
+	return res
+}
@@ -0,0 +1,59 @@
+package fastdu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffScans(t *testing.T) {
+	t1 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	older := []FileState{
+		{"/r/a/same.jpg", 10, t1, "", true},
+		{"/r/a/edited.jpg", 20, t1, "", true},
+		{"/r/a/old-name.jpg", 30, t1, "h1", true},
+		{"/r/b/deleted.mov", 40, t1, "", true},
+		{"/r/b/moved.jpg", 50, t1, "", true},
+		{"/r/b/cached.jpg", 60, t1, "", true}, // hash unknown in the older scan
+		{"/r/b/empty.txt", 0, t1, "", false},
+	}
+	newer := []FileState{
+		{"/r/a/same.jpg", 10, t1, "", true},
+		{"/r/a/edited.jpg", 25, t2, "", true},
+		{"/r/a/new-name.jpg", 30, t2, "h1", true},
+		{"/r/c/moved.jpg", 50, t1, "", true},
+		{"/r/c/cached.jpg", 60, t1, "h2", true},
+		{"/r/c/empty.txt", 0, t1, "", false},
+		{"/r/c/new.mov", 1000, t2, "", true},
+		{"/r/d/backup.tar", 5000, t2, "", false},
+	}
+
+	diff := DiffScans(older, newer)
+	assert.Equal(t, []FileState{{"/r/c/new.mov", 1000, t2, "", true}, {"/r/d/backup.tar", 5000, t2, "", false}}, diff.Added)
+	assert.Equal(t, []FileState{{"/r/b/deleted.mov", 40, t1, "", true}}, diff.Removed)
+	if assert.Len(t, diff.Modified, 1) {
+		assert.Equal(t, "/r/a/edited.jpg", diff.Modified[0].New.Path)
+	}
+	if assert.Len(t, diff.Moved, 4) {
+		assert.Equal(t, "/r/a/old-name.jpg", diff.Moved[0].From.Path)
+		assert.Equal(t, "/r/a/new-name.jpg", diff.Moved[0].To.Path)
+		assert.Equal(t, "/r/b/cached.jpg", diff.Moved[1].From.Path)
+		assert.Equal(t, "/r/c/cached.jpg", diff.Moved[1].To.Path)
+		assert.Equal(t, "/r/b/empty.txt", diff.Moved[2].From.Path)
+		assert.Equal(t, "/r/c/empty.txt", diff.Moved[2].To.Path)
+		assert.Equal(t, "/r/b/moved.jpg", diff.Moved[3].From.Path)
+		assert.Equal(t, "/r/c/moved.jpg", diff.Moved[3].To.Path)
+	}
+
+	// /r and / grew by the same amount; the deeper dir is listed first
+	if assert.NotEmpty(t, diff.Dirs) {
+		assert.Equal(t, DirGrowth{"/r", 210, 6175}, diff.Dirs[0])
+		assert.Equal(t, DirGrowth{"/", 210, 6175}, diff.Dirs[1])
+		assert.Equal(t, DirGrowth{"/r/d", 0, 5000}, diff.Dirs[2])
+		assert.Equal(t, DirGrowth{"/r/c", 0, 1110}, diff.Dirs[3])
+		last := diff.Dirs[len(diff.Dirs)-1]
+		assert.Equal(t, DirGrowth{"/r/b", 150, 0}, last)
+	}
+}
@@ -0,0 +1,181 @@
+package fastdu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+const (
+	// number of bytes hashed from the head and the tail of a file when
+	// narrowing down duplicate candidates
+	partialHashSize = 4096
+	hashWorkers     = 8
+)
+
+// FindDuplicates groups files by content: files are grouped by size first,
+// then by a partial hash of their head and tail and finally by a sha256 of
+// the full content for the remaining candidates. Files that have identical
+// content share the same Hash and list each other in Dups. Hashes restored
+// from the cache are used as is.
+func (d *DirCount) FindDuplicates() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bySize := make(map[int64][]*Meta)
+	for _, m := range d.Meta {
+		bySize[m.Size] = append(bySize[m.Size], m)
+	}
+
+	// groups whose hashes are all cached don't need to be read at all
+	var candidates, hashed [][]*Meta
+	for _, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		if slices.ContainsFunc(group, func(m *Meta) bool { return m.Hash == "" }) {
+			candidates = append(candidates, group)
+		} else {
+			hashed = append(hashed, group)
+		}
+	}
+
+	candidates = regroup(candidates, partialHash)
+	candidates = append(regroup(candidates, fullHash), regroup(hashed, fullHash)...)
+
+	d.dList = d.dList[:0]
+	for _, group := range candidates {
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		dups := make([]Duplicate, 0, len(group))
+		for _, m := range group {
+			dups = append(dups, Duplicate{m.Path, m.Size, m.Hash})
+		}
+		for _, m := range group {
+			// every file gets its own copy since consumers may sort it
+			m.Dups = append([]Duplicate(nil), dups...)
+		}
+		d.dList = append(d.dList, duplicates{group[0].Type, group[0].Hash, dups})
+	}
+	sort.Slice(d.dList, func(i, j int) bool { return d.dList[i].Dups[0].Name < d.dList[j].Dups[0].Name })
+}
+
+// regroup splits every group of files by the key returned from hashFn and
+// returns only the resulting groups that still hold more than one file
+func regroup(groups [][]*Meta, hashFn func(m *Meta) (string, error)) [][]*Meta {
+	type result struct {
+		meta *Meta
+		key  string
+	}
+
+	jobs := make(chan *Meta)
+	results := make(chan result)
+	go func() {
+		defer close(jobs)
+		for _, group := range groups {
+			for _, m := range group {
+				jobs <- m
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(hashWorkers)
+	for i := 0; i < hashWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for m := range jobs {
+				key, err := hashFn(m)
+				if err != nil {
+					log.Printf("hash %s error %v\n", m.Path, err)
+					continue
+				}
+				results <- result{m, key}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// keys only need to be unique within a size group
+	type groupKey struct {
+		size int64
+		key  string
+	}
+	byKey := make(map[groupKey][]*Meta)
+	for r := range results {
+		k := groupKey{r.meta.Size, r.key}
+		byKey[k] = append(byKey[k], r.meta)
+	}
+
+	var res [][]*Meta
+	for _, group := range byKey {
+		if len(group) > 1 {
+			res = append(res, group)
+		}
+	}
+	return res
+}
+
+// partialHash hashes the head and tail of a file
+func partialHash(m *Meta) (string, error) {
+	fd, err := os.Open(m.Path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if m.Size <= 2*partialHashSize {
+		if _, err := io.Copy(h, fd); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	if _, err := io.CopyN(h, fd, partialHashSize); err != nil {
+		return "", err
+	}
+	if _, err := fd.Seek(-partialHashSize, io.SeekEnd); err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(h, fd, partialHashSize); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fullHash computes the sha256 of the file content and records it on m; a
+// hash restored from the cache is returned as is
+func fullHash(m *Meta) (string, error) {
+	if m.Hash != "" {
+		return m.Hash, nil
+	}
+	sum, err := HashFile(m.Path)
+	if err != nil {
+		return "", err
+	}
+	m.Hash = sum
+	return sum, nil
+}
+
+// HashFile returns the hex encoded sha256 of the file content
+func HashFile(file string) (string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,59 @@
+package fastdu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirCount_FindDuplicates(t *testing.T) {
+	png, err := os.ReadFile("../testdata/Thumb/dont_skip.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := append(append([]byte{}, png...), 0) // same prefix, different content
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a/IMG_0001.png":    png,
+		"b/renamed.png":     png,
+		"c/IMG_0001.png":    other,
+		"d/unique_size.png": append(append([]byte{}, png...), 1, 2),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := NewDirCount("")
+	for name := range files {
+		path := filepath.Join(dir, name)
+		fInfo, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.AddFile(filepath.Dir(path), fInfo)
+	}
+	d.FindDuplicates()
+
+	a := d.Meta[filepath.Join(dir, "a/IMG_0001.png")]
+	b := d.Meta[filepath.Join(dir, "b/renamed.png")]
+	c := d.Meta[filepath.Join(dir, "c/IMG_0001.png")]
+	u := d.Meta[filepath.Join(dir, "d/unique_size.png")]
+
+	// renamed copy is detected, same name with different content is not
+	assert.NotEmpty(t, a.Hash)
+	assert.Equal(t, a.Hash, b.Hash)
+	assert.Len(t, a.Dups, 2)
+	assert.Len(t, c.Dups, 1)
+	assert.True(t, c.FileSizeMismatch)
+	assert.Empty(t, u.Hash) // unique size is never hashed
+	assert.Len(t, d.dList, 1)
+}
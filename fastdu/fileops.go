@@ -0,0 +1,94 @@
+package fastdu
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrReflinkUnsupported is returned by Reflink when the platform or file
+// system can't share extents between files
+var ErrReflinkUnsupported = errors.New("reflink not supported")
+
+// CopyFile copies the content and permissions of src to a new file dst
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// MoveFile renames src to dst. Across devices src is copied, and removed
+// after the copy is verified against its hash and given its modification
+// time.
+func MoveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return moveAcross(src, dst)
+}
+
+// moveAcross moves src to dst by copying
+func moveAcross(src, dst string) error {
+	fInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	hash, err := HashFile(src)
+	if err != nil {
+		return err
+	}
+	if err := CopyFile(src, dst); err != nil {
+		return err
+	}
+	if sum, err := HashFile(dst); err != nil || sum != hash {
+		os.Remove(dst)
+		return fmt.Errorf("%s: verification of the copy failed", dst)
+	}
+	if err := os.Chtimes(dst, fInfo.ModTime(), fInfo.ModTime()); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// replaceWith atomically replaces dst by a file created by create; the new
+// file is created next to dst and renamed over it
+func replaceWith(dst string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".fdu-tmp")
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
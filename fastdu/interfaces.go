@@ -9,7 +9,6 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,37 +36,80 @@ type DUtil interface {
 
 // DirCount is used to store byte totals for all files in specified dir along with meta data
 type DirCount struct {
-	mu    sync.Mutex
-	size  map[string]int64 // store cumulative totals of file sizes by dir hierarchy
-	Meta  map[string]*Meta // file name (not absolute path) -> meta data map
-	dList []duplicates     // duplicate list for current search
+	mu     sync.Mutex
+	skip   *regexp.Regexp // files matching this pattern are skipped
+	counts Counters
+	roots  map[string]bool     // scan roots; totals are not rolled up above them
+	dirs   map[string]*DirStat // cumulative totals by dir hierarchy
+	Meta   map[string]*Meta    // file path -> meta data map
+	byName map[string][]*Meta  // base file name -> meta data, used to flag size mismatches
+	dList  []duplicates        // duplicate list for current search
+
+	plain []CacheEntry          // sniffed files that aren't media, kept for the cache
+	files map[string][]FileNode // dir -> files counted in it, if KeepFiles is set
+
+	PerceptualHash bool     // compute perceptual hash of images to find near duplicates
+	SizeMode       SizeMode // size reported by PrintFiles and size sorted output
+	KeepFiles      bool     // keep the counted files of every dir for Tree
+	Cache          Cache    // content derived data of a previous scan, optional
+}
+
+// DirStat holds cumulative totals of a dir and everything below it
+type DirStat struct {
+	Size     int64 // total apparent size of files
+	DiskSize int64 // total allocated size of files
+	Files    int64 // number of files
+	Dirs     int64 // number of sub dirs
 }
 
 // Meta stores metadata about the file such as os.stat info, filetype info
 type Meta struct {
-	Name    string // base file name
-	Size    int64
-	Modtime time.Time
+	Name     string // base file name
+	Path     string // full file path
+	Size     int64
+	DiskSize int64  // allocated size; less than Size for sparse files
+	Device   uint64 // device and inode identify the file across hard links
+	Inode    uint64
+	Modtime  time.Time
 	types.Type
 	Exif             exif2.Exif
+	DateTimeOriginal time.Time     // from exif, zero if unknown
+	CreateDate       time.Time     // from exif, zero if unknown
+	MediaCreated     time.Time     // creation time of the video container, zero if unknown
+	Duration         time.Duration // of video and audio, zero if unknown
+	Width            int           // frame size of videos, zero if unknown
+	Height           int
+	Location         *Location // where a video was recorded, nil if unknown
+	Tags             MediaTags // title, artist, ... of audio and video files
+	ContentID        string    // Live Photo identifier shared by the still and its video, empty if unknown
 	FileSizeMismatch bool
-	Dups             []Duplicate // potential list of duplicates
+	Hash             string      // sha256 of file content; only computed for files that share a size
+	PHash            string      // perceptual hash of image content (hex), empty if not computed
+	Dups             []Duplicate // files with identical content, including this one
 }
 
 type duplicates struct {
 	types.Type
+	Hash string
 	Dups []Duplicate
 }
 
 type Duplicate struct {
-	Name string
+	Name string // full file path
 	Size int64
+	Hash string
 }
 
 type fileInfo struct {
 	isMedia bool
 	types.Type
-	exif exif2.Exif
+	exif  exif2.Exif
+	hash  string // content hash, only known for cached files
+	phash string // perceptual hash of images, if requested
+
+	dateTimeOriginal time.Time
+	createDate       time.Time
+	media            MediaInfo // of video and audio files
 }
 
 type Counters struct {
@@ -77,40 +119,66 @@ type Counters struct {
 	ImageCnt            atomic.Int64
 	FileSizeMismatchCnt atomic.Int64
 	FilesSkipCnt        atomic.Int64
+	HardLinkCnt         atomic.Int64 // additional links to files that were already counted
+	SparseFileCnt       atomic.Int64
+	SymlinkCnt          atomic.Int64
+	BrokenLinkCnt       atomic.Int64 // symlinks whose target doesn't exist
+	FollowedLinkCnt     atomic.Int64 // files reached again through followed symlinks
+	CacheHitCnt         atomic.Int64 // files whose content wasn't read since they were cached
 }
 
-var (
+const (
 	// first 261 bytes is sufficient to identify file type
-	fileBuf = make([]byte, 261)
-	counts  Counters
+	fileBufSize = 261
 	// skip thumb nail files etc.,; use raw strings to avoid backslashes
-	skipFiles      = `/Thumbs/|@eaDir|/rep/ssd/` // add other skip files after specifying '|' for 'OR'ing
-	skipFilesRegex = regexp.MustCompile(skipFiles)
+	skipFiles = `/Thumbs/|@eaDir|/rep/ssd/` // add other skip files after specifying '|' for 'OR'ing
 )
 
+// skipPattern returns the default skip files pattern combined with skipPat
+func skipPattern(skipPat string) string {
+	if skipPat == "" {
+		return skipFiles
+	}
+	return skipFiles + "|" + skipPat
+}
+
 // NewDirCount is a function that returns a new DirCount that
-// implements DUtil
+// implements DUtil; skipPat is added to the default skip files pattern
 func NewDirCount(skipPat string) *DirCount {
-	skipFilesRegex = regexp.MustCompile(skipPat)
-	return &DirCount{size: make(map[string]int64),
-		Meta:  make(map[string]*Meta),
-		dList: make([]duplicates, 0), // 0 cap slice since duplciates may not exist
+	return &DirCount{
+		skip:   regexp.MustCompile(skipPattern(skipPat)),
+		roots:  make(map[string]bool),
+		dirs:   make(map[string]*DirStat),
+		Meta:   make(map[string]*Meta),
+		byName: make(map[string][]*Meta),
+		dList:  make([]duplicates, 0), // 0 cap slice since duplciates may not exist
 	}
 }
 
 func (d *DirCount) Counters() string {
-	return counts.String()
+	return d.counts.String()
+}
+
+// CountersSnapshot returns the current values of the counters
+func (d *DirCount) CountersSnapshot() CountersSnapshot {
+	return d.counts.Snapshot()
 }
 
 func (c *Counters) String() string {
 	cntStr := "\n"
-	cntStr += fmt.Sprintf("Exif Errors: %d\nVideo files: %d\nAudio file(s): %d\nImage file(s): %d\nFileSizeMismatch Count: %d\nSkippedFiles:%d\n",
+	cntStr += fmt.Sprintf("Exif Errors: %d\nVideo files: %d\nAudio file(s): %d\nImage file(s): %d\nFileSizeMismatch Count: %d\nSkippedFiles:%d\nHardLinks:%d\nSparseFiles:%d\nSymlinks:%d\nBrokenLinks:%d\nFollowedLinks:%d\nCacheHits:%d\n",
 		c.ExifErrors.Load(),
 		c.VideoCnt.Load(),
 		c.AudioCnt.Load(),
 		c.ImageCnt.Load(),
 		c.FileSizeMismatchCnt.Load(),
 		c.FilesSkipCnt.Load(),
+		c.HardLinkCnt.Load(),
+		c.SparseFileCnt.Load(),
+		c.SymlinkCnt.Load(),
+		c.BrokenLinkCnt.Load(),
+		c.FollowedLinkCnt.Load(),
+		c.CacheHitCnt.Load(),
 	)
 	return cntStr
 }
@@ -120,19 +188,41 @@ func (d *DirCount) WriteMeta(file string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	writeJson(d.Meta, file)
-	// create duplicate files info if any exist
-	for _, m := range d.Meta {
-		// fmt.Println(f, *m)
-		if len(m.Dups) > 1 {
-			d.dList = append(d.dList,
-				duplicates{m.Type, m.Dups})
-		}
+	meta := make(map[string]any, len(d.Meta))
+	for path, m := range d.Meta {
+		meta[path] = d.metaJSON(m)
 	}
-
+	writeJson(meta, file)
+	// duplicate files info is created by FindDuplicates
 	writeJson(d.dList, dupFile)
 }
 
+// sizedMeta is the json form of Meta in disk usage mode: Size is the
+// allocated size and ApparentSize the size of the content
+type sizedMeta struct {
+	*Meta
+	Size         int64
+	ApparentSize int64
+}
+
+// metaJSON returns the json form of m whose Size is the size selected by
+// SizeMode
+func (d *DirCount) metaJSON(m *Meta) any {
+	if d.SizeMode != DiskUsage {
+		return m
+	}
+	return sizedMeta{m, m.DiskSize, m.Size}
+}
+
+// metaListJSON returns the json form of a list of meta data
+func (d *DirCount) metaListJSON(list []*Meta) []any {
+	res := make([]any, len(list))
+	for i, m := range list {
+		res[i] = d.metaJSON(m)
+	}
+	return res
+}
+
 // write jsone data to specified file
 func writeJson(d interface{}, file string) {
 
@@ -150,38 +240,112 @@ func writeJson(d interface{}, file string) {
 }
 
 // GetTop returns aggregated totals for the top level
-// directories
+// directories, i.e. the scan roots
 func (d *DirCount) GetTop() map[string]int64 {
 	res := make(map[string]int64)
-	for key, val := range d.size {
-		top := strings.Split(key, "/")
-		res[top[0]] += val
+	for dir, st := range d.dirs {
+		if d.parent(dir) == "" {
+			res[dir] = d.sizeOf(st)
+		}
 	}
 	return res
 }
 
-func getFileInfo(file string) (fileInfo, error) {
+// sizeOf returns the size of the dir totals selected by SizeMode
+func (d *DirCount) sizeOf(st *DirStat) int64 {
+	if d.SizeMode == DiskUsage {
+		return st.DiskSize
+	}
+	return st.Size
+}
+
+// SetRoots records the scan roots; totals of dirs below a root are rolled
+// up to the root but not further
+func (d *DirCount) SetRoots(roots ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, root := range roots {
+		d.roots[filepath.Clean(root)] = true
+	}
+}
+
+// isRoot reports whether path is a scan root
+func (d *DirCount) isRoot(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.roots[filepath.Clean(path)]
+}
+
+// DirStats returns a copy of the cumulative totals by dir
+func (d *DirCount) DirStats() map[string]DirStat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make(map[string]DirStat, len(d.dirs))
+	for dir, st := range d.dirs {
+		res[dir] = *st
+	}
+	return res
+}
+
+// parent returns the parent of dir that totals are rolled up to; empty at
+// a scan root or at the top of the file system
+func (d *DirCount) parent(dir string) string {
+	if d.roots[dir] {
+		return ""
+	}
+	parent := filepath.Dir(dir)
+	if parent == dir {
+		return ""
+	}
+	return parent
+}
+
+// stat returns the totals of dir; missing dirs are created and counted as
+// sub dirs of all their ancestors
+func (d *DirCount) stat(dir string) *DirStat {
+	if st, ok := d.dirs[dir]; ok {
+		return st
+	}
+	st := &DirStat{}
+	d.dirs[dir] = st
+	for p := d.parent(dir); p != ""; p = d.parent(p) {
+		d.stat(p).Dirs++
+	}
+	return st
+}
+
+// countKind counts media files by MIME type and reports whether the type is
+// a media type
+func (d *DirCount) countKind(mimeType string) bool {
+	switch mimeType {
+	case "image":
+		d.counts.ImageCnt.Add(1)
+	case "audio":
+		d.counts.AudioCnt.Add(1)
+	case "video":
+		d.counts.VideoCnt.Add(1)
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *DirCount) getFileInfo(file string) (fileInfo, error) {
 	fd, err := os.Open(file)
 	if err != nil {
 		return fileInfo{}, err
 	}
 	defer fd.Close()
+	fileBuf := make([]byte, fileBufSize)
 	fd.Read(fileBuf)
 
 	kind, _ := filetype.Match(fileBuf)
-	switch kind.MIME.Type {
-	case "image":
-		counts.ImageCnt.Add(1)
-	case "audio":
-		counts.AudioCnt.Add(1)
-	case "video":
-		counts.VideoCnt.Add(1)
-	default:
+	if !d.countKind(kind.MIME.Type) {
 		return fileInfo{}, nil
 	}
 	if kind.MIME.Type == "video" || kind.MIME.Type == "audio" {
-		// no exif for video/audio files
-		return fileInfo{true, kind, exif2.Exif{}}, nil
+		// no exif for video/audio files; their containers have metadata
+		return fileInfo{isMedia: true, Type: kind, media: readMediaInfo(fd)}, nil
 	}
 	// reset file pointer
 	_, err = fd.Seek(0, io.SeekStart)
@@ -191,11 +355,25 @@ func getFileInfo(file string) (fileInfo, error) {
 	exifData, err := imagemeta.Decode(fd)
 	if err != nil {
 		// log.Printf(">>exif error %s %v\n", file, err)
-		counts.ExifErrors.Add(1)
+		d.counts.ExifErrors.Add(1)
 		exifData = exif2.Exif{}
-		return fileInfo{true, kind, exifData}, nil
 	}
-	return fileInfo{true, kind, exifData}, nil
+	info := fileInfo{isMedia: true, Type: kind, exif: exifData}
+	info.dateTimeOriginal, info.createDate = exifData.DateTimeOriginal(), exifData.CreateDate()
+	info.media.ContentID = livePhotoID(fd)
+	if d.PerceptualHash {
+		info.phash = perceptualHash(fd)
+	}
+	return info, nil
+}
+
+// plainEntry returns the cache entry of a file that isn't a media file
+func plainEntry(file string, fInfo os.FileInfo) CacheEntry {
+	e := CacheEntry{Path: file, Size: fInfo.Size(), Modtime: fInfo.ModTime()}
+	if st, ok := statOf(fInfo); ok {
+		e.Inode = st.ino
+	}
+	return e
 }
 
 // AddFile can accept a path to dir or file as first argument
@@ -206,68 +384,118 @@ func (d *DirCount) AddFile(dir string, fInfo os.FileInfo) {
 			log.Printf("Recovering from panic while processing %s, fileInfo: %v", file, fInfo)
 		}
 	}()
-	d.mu.Lock()
-	defer d.mu.Unlock()
-
 	if fInfo.IsDir() {
 		log.Printf("error: expecting file got dir: %s", file)
 		return
 	}
 
 	file = filepath.Join(dir, fInfo.Name())
-	if skipFilesRegex.MatchString(file) {
-		counts.FilesSkipCnt.Add(1)
+	if d.skip.MatchString(file) {
+		d.counts.FilesSkipCnt.Add(1)
 		return
 	}
 
+	// empty files have no content to sniff; they are recorded so that diffs
+	// see them created, removed and moved
 	if fInfo.Size() == 0 {
+		d.mu.Lock()
+		d.plain = append(d.plain, plainEntry(file, fInfo))
+		d.mu.Unlock()
 		return
 	}
 
-	imageInfo, err := getFileInfo(file)
-	if err != nil {
-		log.Printf("getFileInfo %s error %v\n", file, err)
-		return
+	// file content is read without holding the lock so that files can be
+	// processed concurrently
+	imageInfo, cached := d.cachedFileInfo(file, fInfo)
+	if !cached {
+		var err error
+		if imageInfo, err = d.getFileInfo(file); err != nil {
+			log.Printf("getFileInfo %s error %v\n", file, err)
+			return
+		}
 	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if !imageInfo.isMedia {
+		// recorded so that the next scan doesn't sniff the file again
+		d.plain = append(d.plain, plainEntry(file, fInfo))
 		return
 	}
 
 	base := filepath.Base(file)
-	var meta *Meta
-	var ok bool
-
-	meta, ok = d.Meta[base]
-	if ok {
-		if d.Meta[base].Size != fInfo.Size() {
-			counts.FileSizeMismatchCnt.Add(1)
-			d.Meta[base].FileSizeMismatch = true
-		}
-	} else {
-		// log.Printf("modtime: %s, truncated time %s", fInfo.ModTime(), fInfo.ModTime().Format(time.RFC3339))
-		meta = &Meta{
-			base,
-			fInfo.Size(),
-			fInfo.ModTime(),
-			// fInfo.ModTime().Truncate(time.Second),
-			imageInfo.Type,
-			imageInfo.exif,
-			false, // FileSizeMismatch
-			nil,
-		}
-		if fInfo.Size() == 0 {
-			fmt.Printf("Error storing empty file %s\n", base)
+	meta := &Meta{
+		Name:     base,
+		Path:     file,
+		Size:     fInfo.Size(),
+		DiskSize: fInfo.Size(),
+		Modtime:  fInfo.ModTime(),
+		Type:     imageInfo.Type,
+		Exif:     imageInfo.exif,
+		Hash:     imageInfo.hash,
+		PHash:    imageInfo.phash,
+
+		DateTimeOriginal: imageInfo.dateTimeOriginal,
+		CreateDate:       imageInfo.createDate,
+		MediaCreated:     imageInfo.media.Created,
+		Duration:         imageInfo.media.Duration,
+		Width:            imageInfo.media.Width,
+		Height:           imageInfo.media.Height,
+		Location:         imageInfo.media.Location,
+		Tags:             imageInfo.media.Tags,
+		ContentID:        imageInfo.media.ContentID,
+	}
+	if st, ok := statOf(fInfo); ok {
+		meta.DiskSize = st.diskSize
+		meta.Device = st.dev
+		meta.Inode = st.ino
+	}
+	meta.Dups = []Duplicate{{file, fInfo.Size(), ""}}
+
+	// files sharing a name but not a size are flagged; content duplicates
+	// are identified separately by FindDuplicates
+	mismatch := false
+	for _, m := range d.byName[base] {
+		if m.Size != meta.Size {
+			m.FileSizeMismatch = true
+			mismatch = true
 		}
-		d.Meta[base] = meta
 	}
-	d.Meta[base].Dups = append(d.Meta[base].Dups, Duplicate{file, fInfo.Size()})
+	if mismatch {
+		d.counts.FileSizeMismatchCnt.Add(1)
+		meta.FileSizeMismatch = true
+	}
+	d.byName[base] = append(d.byName[base], meta)
+	d.Meta[file] = meta
 }
 
-// Inc increases the cumulative file size count by directory
+// Inc adds a file of the specified size to the totals of the directory
+// and all of its ancestors
 func (d *DirCount) Inc(path string, size int64) {
+	d.IncUsage(path, size, size)
+}
+
+// IncUsage adds a file of the specified apparent and allocated size to the
+// totals of the directory and all of its ancestors
+func (d *DirCount) IncUsage(path string, size, diskSize int64) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.size[path] += size
+	path = filepath.Clean(path)
+	d.stat(path)
+	for p := path; p != ""; p = d.parent(p) {
+		st := d.dirs[p]
+		st.Size += size
+		st.DiskSize += diskSize
+		st.Files++
+	}
+}
+
+// AddDir records a directory so that it is counted even if it holds no files
+func (d *DirCount) AddDir(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stat(filepath.Clean(path))
 }
 
 // WriteMetaSortedByDate prints meta data sorted by date
@@ -281,7 +509,7 @@ func (d *DirCount) WriteMetaSortedByDate(file string) {
 	}
 
 	sort.Sort(SortedMetaByDate(m))
-	b, err := json.MarshalIndent(m, "  ", "  ")
+	b, err := json.MarshalIndent(d.metaListJSON(m), "  ", "  ")
 	if err != nil {
 		fmt.Println("error:", err)
 	}
@@ -306,8 +534,12 @@ func (d *DirCount) WriteMetaSortedBySize(file string) {
 		m = append(m, v)
 	}
 
-	sort.Sort(SortedFileBySize(m))
-	writeMetaData(file, m)
+	if d.SizeMode == DiskUsage {
+		sort.Sort(SortedFileByDiskSize(m))
+	} else {
+		sort.Sort(SortedFileBySize(m))
+	}
+	writeMetaData(file, d.metaListJSON(m))
 }
 
 // writeMetaData writes data to file
@@ -330,20 +562,19 @@ func writeMetaData(file string, data interface{}) {
 func (d *DirCount) PrintFiles(topFiles int, summary bool) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	fmt.Println("size len:", len(d.size))
-
-	sumKeys := d.GetTop()
+	fmt.Println("size len:", len(d.dirs))
 
-	var keys []string
 	var dc map[string]int64
 
 	if summary {
-		keys = SortedKeys(sumKeys)
-		dc = sumKeys
+		dc = d.GetTop()
 	} else {
-		keys = SortedKeys(d.size)
-		dc = d.size
+		dc = make(map[string]int64, len(d.dirs))
+		for dir, st := range d.dirs {
+			dc[dir] = d.sizeOf(st)
+		}
 	}
+	keys := SortedKeys(dc)
 
 	if topFiles > len(keys) || topFiles == -1 {
 		fmt.Printf("Printing top available %d\n ", len(keys))
@@ -353,24 +584,30 @@ func (d *DirCount) PrintFiles(topFiles int, summary bool) {
 	}
 
 	for _, key := range keys {
-		size := float64(dc[key])
-		sizeGB := size / 1e9
-		sizeMB := size / 1e6
-		sizeKB := size / 1e3
-		var units string
-
-		switch {
-		case sizeGB > 0.09:
-			size = sizeGB
-			units = "GB"
-		case sizeMB > 0.09:
-			size = sizeMB
-			units = "MB"
-		default:
-			size = sizeKB
-			units = "KB"
+		st := d.dirs[key]
+		fmt.Printf("%s, %d files, %d dirs, %s\n", FormatSize(d.sizeOf(st)), st.Files, st.Dirs, key)
+	}
+}
+
+// FormatSize formats a byte count in KB, MB or GB similar to du -h
+func FormatSize(nbytes int64) string {
+	size := float64(nbytes)
+	sizeGB := size / 1e9
+	sizeMB := size / 1e6
+	sizeKB := size / 1e3
+	var units string
+
+	switch {
+	case sizeGB > 0.09:
+		size = sizeGB
+		units = "GB"
+	case sizeMB > 0.09:
+		size = sizeMB
+		units = "MB"
+	default:
+		size = sizeKB
+		units = "KB"
 
-		}
-		fmt.Printf("%.1f%s, %s\n", size, units, key)
 	}
+	return fmt.Sprintf("%.1f%s", size, units)
 }
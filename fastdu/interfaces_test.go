@@ -1,6 +1,7 @@
 package fastdu
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -33,7 +34,7 @@ func TestDirCount_AddFile(t *testing.T) {
 				fname: "skip.png",
 			},
 			checker: func(got *DirCount) {
-				assert.NotContains(t, got.Meta, "skip.png")
+				assert.NotContains(t, got.Meta, filepath.Join("../testdata/Thumbs", "skip.png"))
 			},
 		},
 		{
@@ -43,7 +44,7 @@ func TestDirCount_AddFile(t *testing.T) {
 				fname: "skip.png",
 			},
 			checker: func(got *DirCount) {
-				assert.NotContains(t, got.Meta, "skip.png")
+				assert.NotContains(t, got.Meta, filepath.Join("../testdata/@eaDir", "skip.png"))
 			},
 		},
 		{
@@ -53,7 +54,7 @@ func TestDirCount_AddFile(t *testing.T) {
 				fname: "skip.png",
 			},
 			checker: func(got *DirCount) {
-				assert.NotContains(t, got.Meta, "skip.png")
+				assert.NotContains(t, got.Meta, filepath.Join("../testdata/rep/ssd", "skip.png"))
 			},
 		},
 		{
@@ -63,14 +64,11 @@ func TestDirCount_AddFile(t *testing.T) {
 				fname: "dont_skip.png",
 			},
 			checker: func(got *DirCount) {
-				assert.Contains(t, got.Meta, "dont_skip.png")
+				assert.Contains(t, got.Meta, filepath.Join("../testdata/Thumb", "dont_skip.png"))
 			},
 		},
 	}
-	d := &DirCount{
-		Meta:  map[string]*Meta{},
-		dList: []duplicates{},
-	}
+	d := NewDirCount("")
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			filePath := filepath.Join(tt.args.dir, tt.args.fname)
@@ -81,3 +79,59 @@ func TestDirCount_AddFile(t *testing.T) {
 		})
 	}
 }
+
+func TestDirCount_Inc(t *testing.T) {
+	d := NewDirCount("")
+	d.SetRoots("/r1/", "/r2")
+	d.Inc("/r1/a/b", 10)
+	d.Inc("/r1/a", 5)
+	d.Inc("/r1/c/", 1)
+	d.AddDir("/r1/empty")
+	d.Inc("/r2", 100)
+
+	stats := d.DirStats()
+	assert.Equal(t, DirStat{Size: 16, DiskSize: 16, Files: 3, Dirs: 4}, stats["/r1"])
+	assert.Equal(t, DirStat{Size: 15, DiskSize: 15, Files: 2, Dirs: 1}, stats["/r1/a"])
+	assert.Equal(t, DirStat{Size: 10, DiskSize: 10, Files: 1, Dirs: 0}, stats["/r1/a/b"])
+	assert.NotContains(t, stats, "/") // not rolled up above roots
+
+	assert.Equal(t, map[string]int64{"/r1": 16, "/r2": 100}, d.GetTop())
+}
+
+func TestDirCount_WriteMetaSortedBySize(t *testing.T) {
+	d := NewDirCount("")
+	d.Meta["/a/sparse.img"] = &Meta{Path: "/a/sparse.img", Size: 1 << 30, DiskSize: 4096}
+	d.Meta["/a/b.jpg"] = &Meta{Path: "/a/b.jpg", Size: 8000, DiskSize: 8192}
+	file := filepath.Join(t.TempDir(), "size-info.json")
+
+	// the json outputs follow the size mode
+	d.SizeMode = DiskUsage
+	d.WriteMetaSortedBySize(file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		Path         string
+		Size         int64
+		ApparentSize int64
+	}
+	assert.NoError(t, json.Unmarshal(b, &got))
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "/a/sparse.img", got[0].Path)
+		assert.Equal(t, int64(4096), got[0].Size)
+		assert.Equal(t, int64(1<<30), got[0].ApparentSize)
+		assert.Equal(t, int64(8192), got[1].Size)
+	}
+
+	d.SizeMode = ApparentSize
+	d.WriteMetaSortedBySize(file)
+	b, _ = os.ReadFile(file)
+	got = nil
+	assert.NoError(t, json.Unmarshal(b, &got))
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "/a/b.jpg", got[0].Path)
+		assert.Equal(t, int64(8000), got[0].Size)
+		assert.Zero(t, got[0].ApparentSize)
+	}
+}
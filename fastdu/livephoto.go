@@ -0,0 +1,63 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const (
+	// appleMakerNote starts the maker note in the exif of iPhone photos
+	appleMakerNote = "Apple iOS\x00"
+	// appleContentIDTag is the maker note tag of the identifier that the
+	// still of a Live Photo shares with its video
+	appleContentIDTag = 0x11
+	// livePhotoScanSize is the number of bytes searched for the maker note;
+	// the exif of JPEG files and of iPhone HEIC files is at the start
+	livePhotoScanSize = 128 << 10
+)
+
+// livePhotoID returns the Live Photo content identifier of an iPhone photo,
+// empty if the photo has none
+func livePhotoID(r io.ReaderAt) string {
+	buf := make([]byte, livePhotoScanSize)
+	n, _ := r.ReadAt(buf, 0)
+	i := bytes.Index(buf[:n], []byte(appleMakerNote))
+	if i < 0 {
+		return ""
+	}
+	return makerNoteString(buf[i:n], appleContentIDTag)
+}
+
+// makerNoteString returns the ASCII value of tag in an Apple maker note:
+// the signature and a version are followed by the byte order and an IFD
+// whose offsets are relative to the start of the maker note
+func makerNoteString(note []byte, tag uint16) string {
+	if len(note) < 16 {
+		return ""
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(note[12:14]) == "II" {
+		order = binary.LittleEndian
+	}
+	count := int(order.Uint16(note[14:]))
+	for i, p := 0, 16; i < count && p+12 <= len(note); i, p = i+1, p+12 {
+		entry := note[p : p+12]
+		// type 2 is ASCII
+		if order.Uint16(entry) != tag || order.Uint16(entry[2:]) != 2 {
+			continue
+		}
+		n := int(order.Uint32(entry[4:]))
+		value := entry[8:]
+		if n > 4 {
+			off := int(order.Uint32(entry[8:]))
+			if off < 0 || n > len(note) || off > len(note)-n {
+				return ""
+			}
+			value = note[off:]
+		}
+		return strings.TrimRight(string(value[:min(n, len(value))]), "\x00 ")
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// appleNote returns an Apple maker note with the ASCII entries by tag
+func appleNote(order byteOrder, entries map[uint16]string) []byte {
+	note := []byte(appleMakerNote + "\x00\x01")
+	if order.String() == binary.LittleEndian.String() {
+		note = append(note, "II"...)
+	} else {
+		note = append(note, "MM"...)
+	}
+	note = order.AppendUint16(note, uint16(len(entries)))
+	var data []byte
+	dataStart := 16 + 12*len(entries)
+	for _, tag := range []uint16{0x1, 0x11} {
+		value, ok := entries[tag]
+		if !ok {
+			continue
+		}
+		value += "\x00"
+		note = order.AppendUint16(note, tag)
+		note = order.AppendUint16(note, 2)
+		note = order.AppendUint32(note, uint32(len(value)))
+		if len(value) <= 4 {
+			note = append(note, []byte(value + "\x00\x00\x00")[:4]...)
+			continue
+		}
+		note = order.AppendUint32(note, uint32(dataStart+len(data)))
+		data = append(data, value...)
+	}
+	return append(note, data...)
+}
+
+func TestLivePhotoID(t *testing.T) {
+	id := "5A3E1F0C-8D2B-4F6A-9C1E-0B7D2E4F6A81"
+	for _, order := range []byteOrder{binary.BigEndian, binary.LittleEndian} {
+		// the maker note is somewhere in the exif near the start of the file
+		file := bytes.Join([][]byte{[]byte("\xff\xd8\xff\xe1junk"), appleNote(order, map[uint16]string{0x1: "ab", 0x11: id}),
+			make([]byte, 100)}, nil)
+		assert.Equal(t, id, livePhotoID(bytes.NewReader(file)), "%v", order)
+	}
+
+	assert.Equal(t, "", livePhotoID(bytes.NewReader(appleNote(binary.BigEndian, map[uint16]string{0x1: "ab"}))))
+	assert.Equal(t, "", livePhotoID(bytes.NewReader([]byte("\xff\xd8\xff\xe1 no maker note"))))
+	// offsets past the end
+	note := appleNote(binary.BigEndian, map[uint16]string{0x11: id})
+	assert.Equal(t, "", livePhotoID(bytes.NewReader(note[:len(note)-10])))
+}
@@ -0,0 +1,126 @@
+package fastdu
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaInfo is the metadata of a video or audio file read from its
+// container: MP4/MOV atoms, Matroska elements, ID3 tags or Vorbis comments
+type MediaInfo struct {
+	Created  time.Time     // creation time of the recording, zero if unknown
+	Duration time.Duration // zero if unknown
+	Width    int           // frame size of videos
+	Height   int
+	Location *Location `json:",omitempty"` // where a video was recorded
+	Tags     MediaTags
+	// ContentID is the identifier shared by the still and the video of a
+	// Live Photo; it is also read from images
+	ContentID string `json:",omitempty"`
+}
+
+// Location is a position in decimal degrees; the altitude is in meters and
+// nil if unknown
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64 `json:",omitempty"`
+}
+
+// MediaTags are the descriptive tags of audio and video files
+type MediaTags struct {
+	Title  string `json:",omitempty"`
+	Artist string `json:",omitempty"`
+	Album  string `json:",omitempty"`
+	Genre  string `json:",omitempty"`
+	Year   int    `json:",omitempty"`
+}
+
+// mediaInfo returns the container metadata of a video or audio file
+func (m *Meta) mediaInfo() MediaInfo {
+	return MediaInfo{Created: m.MediaCreated, Duration: m.Duration, Width: m.Width, Height: m.Height,
+		Location: m.Location, Tags: m.Tags, ContentID: m.ContentID}
+}
+
+// set sets the tag of a tag name of any of the tag formats, such as TITLE,
+// TIT2 or ©nam; the first value of a tag wins
+func (t *MediaTags) set(name, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	// MP4 item names start with ©, which isn't valid UTF-8 on its own
+	if !strings.HasPrefix(name, "\xa9") {
+		name = strings.ToLower(name)
+	}
+	field := map[string]*string{
+		"title": &t.Title, "\xa9nam": &t.Title, "tit2": &t.Title, "tt2": &t.Title,
+		"artist": &t.Artist, "\xa9ART": &t.Artist, "tpe1": &t.Artist, "tp1": &t.Artist,
+		"album": &t.Album, "\xa9alb": &t.Album, "talb": &t.Album, "tal": &t.Album,
+		"genre": &t.Genre, "\xa9gen": &t.Genre, "tcon": &t.Genre, "tco": &t.Genre,
+	}[name]
+	switch {
+	case field != nil && *field == "":
+		*field = value
+	case field == nil && t.Year == 0:
+		switch name {
+		case "date", "year", "\xa9day", "tyer", "tdrc", "tye", "date_recorded":
+			if len(value) >= 4 {
+				t.Year, _ = strconv.Atoi(value[:4])
+			}
+		}
+	}
+}
+
+// iso6709 matches the locations of videos, e.g. +37.3349-122.0090+010.000/
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// parseISO6709 parses a location in decimal degrees as written by cameras
+// and phones
+func parseISO6709(s string) (*Location, bool) {
+	m := iso6709.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return nil, false
+	}
+	var loc Location
+	loc.Latitude, _ = strconv.ParseFloat(m[1], 64)
+	loc.Longitude, _ = strconv.ParseFloat(m[2], 64)
+	if m[3] != "" {
+		if alt, err := strconv.ParseFloat(m[3], 64); err == nil {
+			loc.Altitude = &alt
+		}
+	}
+	if loc.Latitude < -90 || loc.Latitude > 90 || loc.Longitude < -180 || loc.Longitude > 180 {
+		return nil, false
+	}
+	return &loc, true
+}
+
+// readMediaInfo reads the metadata of a video or audio file; the container
+// is recognized by its header, and fields that aren't found are left empty
+func readMediaInfo(r io.ReadSeeker) MediaInfo {
+	var hdr [12]byte
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return MediaInfo{}
+	}
+	n, _ := io.ReadFull(r, hdr[:])
+	b := hdr[:n]
+	switch {
+	case bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return matroskaInfo(r)
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return flacInfo(r)
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return oggInfo(r)
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return id3Info(r)
+	case len(b) >= 8 && isBoxType(string(b[4:8])):
+		return mp4Info(r)
+	}
+	// mp3 files without ID3v2 header may end with an ID3v1 tag
+	return id3Info(r)
+}
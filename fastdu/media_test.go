@@ -0,0 +1,41 @@
+package fastdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// altitude returns a pointer to the altitude in meters
+func altitude(m float64) *float64 {
+	return &m
+}
+
+func TestParseISO6709(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Location
+	}{
+		{"+37.3349-122.0090+010.000/", &Location{37.3349, -122.009, altitude(10)}},
+		{"+37.3349-122.0090+000.000/", &Location{37.3349, -122.009, altitude(0)}}, // sea level
+		{"-33.8568+151.2153/", &Location{-33.8568, 151.2153, nil}},
+		{"+48.8584+002.2945", &Location{48.8584, 2.2945, nil}},
+		{"+91.0000+000.0000/", nil},
+		{"unknown", nil},
+	}
+	for _, tt := range tests {
+		got, ok := parseISO6709(tt.in)
+		assert.Equal(t, tt.want != nil, ok, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestMediaTags_set(t *testing.T) {
+	var tags MediaTags
+	tags.set("TITLE", " Song \x00")
+	tags.set("TIT2", "other title")
+	tags.set("\xa9ART", "Ann")
+	tags.set("DATE", "2019-03-12")
+	tags.set("unknown", "value")
+	assert.Equal(t, MediaTags{Title: "Song", Artist: "Ann", Year: 2019}, tags)
+}
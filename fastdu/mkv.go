@@ -0,0 +1,292 @@
+package fastdu
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Matroska (MKV, WebM) element ids
+const (
+	ebmlID           = 0x1a45dfa3
+	segmentID        = 0x18538067
+	seekHeadID       = 0x114d9b74
+	seekID           = 0x4dbb
+	seekIDID         = 0x53ab
+	seekPositionID   = 0x53ac
+	infoID           = 0x1549a966
+	timestampScaleID = 0x2ad7b1
+	durationID       = 0x4489
+	dateUTCID        = 0x4461
+	titleID          = 0x7ba9
+	tracksID         = 0x1654ae6b
+	trackEntryID     = 0xae
+	videoID          = 0xe0
+	pixelWidthID     = 0xb0
+	pixelHeightID    = 0xba
+	tagsID           = 0x1254c367
+	tagID            = 0x7373
+	simpleTagID      = 0x67c8
+	tagNameID        = 0x45a3
+	tagStringID      = 0x4487
+	clusterID        = 0x1f43b675
+)
+
+const (
+	maxElementRead  = 1 << 20 // larger seek heads, infos, tracks and tags are skipped
+	maxSegmentItems = 1000    // top level elements visited before giving up
+)
+
+// mkvEpoch is the origin of Matroska dates
+var mkvEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ebmlVint decodes the variable length integer at the start of b; ids keep
+// their length marker and sizes don't. It returns the value and its length.
+func ebmlVint(b []byte, marker bool) (uint64, int, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false
+	}
+	n := bits.LeadingZeros8(b[0]) + 1
+	if len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(b[0])
+	if !marker {
+		v &= 0xff >> n
+	}
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n, true
+}
+
+// ebmlUnknown reports whether size is the reserved size of elements whose
+// size is unknown, such as the segment of a live stream
+func ebmlUnknown(size uint64, n int) bool {
+	return size == 1<<(7*n)-1
+}
+
+// eachElement calls fn with the id and body of the elements of b; a
+// truncated element ends the iteration
+func eachElement(b []byte, fn func(id uint64, body []byte)) {
+	for len(b) > 0 {
+		id, n, ok := ebmlVint(b, true)
+		if !ok {
+			return
+		}
+		size, m, ok := ebmlVint(b[n:], false)
+		if !ok || ebmlUnknown(size, m) || size > uint64(len(b)-n-m) {
+			return
+		}
+		fn(id, b[n+m:n+m+int(size)])
+		b = b[n+m+int(size):]
+	}
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// ebmlElement reads the header of the element at off and seeks r to its
+// body; size < 0 means unknown
+func ebmlElement(r io.ReadSeeker, off int64) (id uint64, size int64, hdrLen int64, ok bool) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, 0, 0, false
+	}
+	var hdr [12]byte
+	k, _ := io.ReadFull(r, hdr[:])
+	id, n, ok := ebmlVint(hdr[:k], true)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	s, m, ok := ebmlVint(hdr[n:k], false)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	size = int64(s)
+	if ebmlUnknown(s, m) || s > math.MaxInt64/2 {
+		size = -1
+	}
+	if _, err := r.Seek(off+int64(n+m), io.SeekStart); err != nil {
+		return 0, 0, 0, false
+	}
+	return id, size, int64(n + m), true
+}
+
+// matroskaInfo reads the segment info (date and duration), the frame size of
+// the first video track and the tags of a Matroska or WebM file. Tags are
+// usually written after the clusters and are found through the seek head.
+func matroskaInfo(r io.ReadSeeker) MediaInfo {
+	var info MediaInfo
+	id, size, n, ok := ebmlElement(r, 0)
+	if !ok || id != ebmlID || size < 0 {
+		return info
+	}
+	segOff := n + size
+	id, size, n, ok = ebmlElement(r, segOff)
+	if !ok || id != segmentID {
+		return info
+	}
+	segStart := segOff + n
+	segEnd := int64(-1)
+	if size >= 0 {
+		segEnd = segStart + size
+	}
+
+	tagsPos, tagsRead := int64(-1), false
+	for off, i := segStart, 0; (segEnd < 0 || off < segEnd) && i < maxSegmentItems; i++ {
+		id, size, n, ok := ebmlElement(r, off)
+		if !ok || size < 0 {
+			return info
+		}
+		if id == clusterID {
+			// the media data; skip to the tags if they come later
+			if tagsPos < 0 || tagsRead || segStart+tagsPos <= off {
+				return info
+			}
+			off = segStart + tagsPos
+			continue
+		}
+		if size <= maxElementRead {
+			switch id {
+			case seekHeadID, infoID, tracksID, tagsID:
+				body := make([]byte, size)
+				if _, err := io.ReadFull(r, body); err != nil {
+					return info
+				}
+				switch id {
+				case seekHeadID:
+					if pos, ok := seekPosition(body, tagsID); ok && tagsPos < 0 {
+						tagsPos = pos
+					}
+				case infoID:
+					segmentInfo(body, &info)
+				case tracksID:
+					info.Width, info.Height = videoTrackSize(body)
+				case tagsID:
+					matroskaTags(body, &info)
+					tagsRead = true
+				}
+			}
+		}
+		off += n + size
+	}
+	return info
+}
+
+// seekPosition returns the position of the element with the specified id
+// relative to the segment data from a seek head
+func seekPosition(seekHead []byte, id uint64) (int64, bool) {
+	var pos int64
+	found := false
+	eachElement(seekHead, func(e uint64, seek []byte) {
+		if e != seekID || found {
+			return
+		}
+		var seekTo uint64
+		var p int64 = -1
+		eachElement(seek, func(e uint64, b []byte) {
+			switch e {
+			case seekIDID:
+				seekTo = ebmlUint(b)
+			case seekPositionID:
+				p = int64(ebmlUint(b))
+			}
+		})
+		if seekTo == id && p >= 0 {
+			pos, found = p, true
+		}
+	})
+	return pos, found
+}
+
+func segmentInfo(b []byte, info *MediaInfo) {
+	scale := uint64(1000000) // nanoseconds per tick
+	var duration float64
+	eachElement(b, func(id uint64, v []byte) {
+		switch id {
+		case timestampScaleID:
+			if s := ebmlUint(v); s > 0 {
+				scale = s
+			}
+		case durationID:
+			duration = ebmlFloat(v)
+		case dateUTCID:
+			if len(v) == 8 {
+				if ns := int64(binary.BigEndian.Uint64(v)); ns != 0 {
+					info.Created = mkvEpoch.Add(time.Duration(ns)).Local()
+				}
+			}
+		case titleID:
+			info.Tags.set("title", string(v))
+		}
+	})
+	if duration > 0 {
+		info.Duration = time.Duration(duration * float64(scale))
+	}
+}
+
+// videoTrackSize returns the frame size of the first video track
+func videoTrackSize(tracks []byte) (int, int) {
+	var w, h int
+	eachElement(tracks, func(id uint64, entry []byte) {
+		if id != trackEntryID || w > 0 {
+			return
+		}
+		eachElement(entry, func(id uint64, video []byte) {
+			if id != videoID {
+				return
+			}
+			eachElement(video, func(id uint64, v []byte) {
+				switch id {
+				case pixelWidthID:
+					w = int(ebmlUint(v))
+				case pixelHeightID:
+					h = int(ebmlUint(v))
+				}
+			})
+		})
+	})
+	return w, h
+}
+
+// matroskaTags reads the simple tags, such as TITLE, ARTIST and
+// DATE_RECORDED
+func matroskaTags(tags []byte, info *MediaInfo) {
+	eachElement(tags, func(id uint64, tag []byte) {
+		if id != tagID {
+			return
+		}
+		eachElement(tag, func(id uint64, simple []byte) {
+			if id != simpleTagID {
+				return
+			}
+			var name, value string
+			eachElement(simple, func(id uint64, v []byte) {
+				switch id {
+				case tagNameID:
+					name = string(v)
+				case tagStringID:
+					value = string(v)
+				}
+			})
+			info.Tags.set(name, value)
+		})
+	})
+}
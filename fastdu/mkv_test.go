@@ -0,0 +1,63 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// element returns an EBML element; sizes are written with 8 bytes
+func element(id uint64, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	var e []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(e) > 0 {
+			e = append(e, c)
+		}
+	}
+	e = binary.BigEndian.AppendUint64(e, uint64(len(b))|1<<56)
+	return append(e, b...)
+}
+
+func TestMatroskaInfo(t *testing.T) {
+	created := time.Date(2019, 3, 12, 15, 30, 12, 0, time.UTC)
+	info := element(infoID,
+		element(timestampScaleID, []byte{0x0f, 0x42, 0x40}), // 1 ms
+		element(durationID, binary.BigEndian.AppendUint64(nil, math.Float64bits(90500))),
+		element(dateUTCID, binary.BigEndian.AppendUint64(nil, uint64(created.Sub(mkvEpoch)))),
+		element(titleID, []byte("Trip")))
+	tracks := element(tracksID,
+		element(trackEntryID, element(0xe1)), // audio
+		element(trackEntryID, element(videoID, element(pixelWidthID, []byte{0x07, 0x80}), element(pixelHeightID, []byte{0x04, 0x38}))))
+	tags := element(tagsID, element(tagID,
+		element(simpleTagID, element(tagNameID, []byte("ARTIST")), element(tagStringID, []byte("Ann"))),
+		element(simpleTagID, element(tagNameID, []byte("DATE_RECORDED")), element(tagStringID, []byte("2019-03-12")))))
+	cluster := element(clusterID, make([]byte, 100))
+
+	// the tags follow the clusters and are found through the seek head
+	var seekHead []byte
+	for i := 0; i < 2; i++ {
+		tagsPos := uint64(len(seekHead) + len(info) + len(tracks) + len(cluster))
+		seekHead = element(seekHeadID, element(seekID,
+			element(seekIDID, []byte{0x12, 0x54, 0xc3, 0x67}),
+			element(seekPositionID, binary.BigEndian.AppendUint64(nil, tagsPos))))
+	}
+	file := bytes.Join([][]byte{
+		element(ebmlID, element(0x4282, []byte("matroska"))),
+		element(segmentID, seekHead, info, tracks, cluster, tags),
+	}, nil)
+
+	got := readMediaInfo(bytes.NewReader(file))
+	assert.True(t, created.Equal(got.Created), "got %v", got.Created)
+	assert.Equal(t, 90500*time.Millisecond, got.Duration)
+	assert.Equal(t, 1920, got.Width)
+	assert.Equal(t, 1080, got.Height)
+	assert.Equal(t, MediaTags{Title: "Trip", Artist: "Ann", Year: 2019}, got.Tags)
+
+	// truncated files
+	assert.Equal(t, MediaInfo{}, matroskaInfo(bytes.NewReader(file[:30])))
+}
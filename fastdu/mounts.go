@@ -0,0 +1,36 @@
+package fastdu
+
+import "path/filepath"
+
+// MountPoint is a mount point found below a scan root
+type MountPoint struct {
+	Path    string // path of the mount point as walked
+	FSType  string // filesystem type; empty when the mount table is not available
+	Source  string // mounted device or remote share
+	Skipped bool   // not descended into because of ScanOptions.OneFileSystem
+}
+
+// mountInfo is an entry of the system mount table
+type mountInfo struct {
+	fsType string
+	source string
+}
+
+// mountAt returns the mount table entry for dir if dir is a mount point
+func (s *Scanner) mountAt(dir string) (mountInfo, bool) {
+	if len(s.mountTable) == 0 {
+		return mountInfo{}, false
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return mountInfo{}, false
+	}
+	m, ok := s.mountTable[abs]
+	return m, ok
+}
+
+func (s *Scanner) addMount(m MountPoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mounts = append(s.mounts, m)
+}
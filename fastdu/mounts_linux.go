@@ -0,0 +1,61 @@
+package fastdu
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readMounts parses /proc/self/mountinfo into a map of mount point to
+// filesystem type and source
+func readMounts() map[string]mountInfo {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseMounts(f)
+}
+
+func parseMounts(r io.Reader) map[string]mountInfo {
+	res := make(map[string]mountInfo)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// ex: 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+2 >= len(fields) {
+			continue
+		}
+		res[unescapeMount(fields[4])] = mountInfo{fsType: fields[sep+1], source: fields[sep+2]}
+	}
+	return res
+}
+
+// unescapeMount decodes the octal escapes (ex: \040 for space) used in the
+// mount table
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package fastdu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseMounts(t *testing.T) {
+	table := `23 28 0:22 / /proc rw,relatime - proc proc rw
+36 35 98:0 /mnt1 /mnt/my\040share rw,noatime master:1 - nfs4 nas:/photos rw
+bad line
+`
+	got := parseMounts(strings.NewReader(table))
+	assert.Equal(t, map[string]mountInfo{
+		"/proc":         {"proc", "proc"},
+		"/mnt/my share": {"nfs4", "nas:/photos"},
+	}, got)
+}
+
+func TestScanner_descend(t *testing.T) {
+	dir := t.TempDir()
+	bind := filepath.Join(dir, "bind")
+	plain := filepath.Join(dir, "plain")
+	for _, d := range []string{bind, plain} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, _ := deviceID(fInfo)
+
+	for _, oneFS := range []bool{false, true} {
+		s, err := NewScanner(ScanOptions{OneFileSystem: oneFS})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// simulate a bind mount which keeps the device id
+		s.mountTable = map[string]mountInfo{bind: {"ext4", "/dev/sda1"}}
+
+		_, ok := s.descend(plain, dev, dev)
+		assert.True(t, ok)
+		_, ok = s.descend(bind, dev, dev)
+		assert.Equal(t, !oneFS, ok)
+		assert.Equal(t, []MountPoint{{bind, "ext4", "/dev/sda1", oneFS}}, s.mounts)
+	}
+}
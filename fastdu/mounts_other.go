@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fastdu
+
+// readMounts is not supported on this platform; mount points are detected
+// by device id only
+func readMounts() map[string]mountInfo {
+	return nil
+}
@@ -0,0 +1,165 @@
+package fastdu
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders used by perceptualHash
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+const (
+	// dHash compares horizontally adjacent cells of a 9x8 grayscale thumbnail
+	dHashWidth  = 9
+	dHashHeight = 8
+	// number of points sampled per axis in each cell; avoids visiting every
+	// pixel of large photos
+	cellSamples = 8
+)
+
+// perceptualHash decodes the image and returns its dHash in hex; an empty
+// string is returned for formats that can't be decoded (ex: heic)
+func perceptualHash(r io.ReadSeeker) string {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%016x", dHash(img))
+}
+
+// dHash computes a difference hash: the image is reduced to a 9x8 grayscale
+// grid and each bit records whether brightness increases from left to right.
+// Resized or re-encoded copies of an image produce the same or a close hash.
+func dHash(img image.Image) uint64 {
+	b := img.Bounds()
+	var gray [dHashHeight][dHashWidth]float64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth; x++ {
+			x0 := b.Min.X + x*b.Dx()/dHashWidth
+			x1 := b.Min.X + (x+1)*b.Dx()/dHashWidth
+			y0 := b.Min.Y + y*b.Dy()/dHashHeight
+			y1 := b.Min.Y + (y+1)*b.Dy()/dHashHeight
+			gray[y][x] = cellLuma(img, x0, y0, max(x1, x0+1), max(y1, y0+1))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// cellLuma returns the average luma of points sampled in the rectangle
+func cellLuma(img image.Image, x0, y0, x1, y1 int) float64 {
+	var sum float64
+	var n int
+	for i := 0; i < cellSamples; i++ {
+		y := y0 + i*(y1-y0)/cellSamples
+		for j := 0; j < cellSamples; j++ {
+			x := x0 + j*(x1-x0)/cellSamples
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// HammingDistance returns the number of differing bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FindNearDuplicates clusters images whose perceptual hashes are within
+// threshold bits of each other. Only clusters with more than one image are
+// returned, each sorted by path.
+func (d *DirCount) FindNearDuplicates(threshold int) [][]*Meta {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var metas []*Meta
+	var hashes []uint64
+	for _, m := range d.Meta {
+		if m.PHash == "" {
+			continue
+		}
+		h, err := strconv.ParseUint(m.PHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		metas = append(metas, m)
+		hashes = append(hashes, h)
+	}
+
+	// two hashes within threshold bits must agree on at least one of
+	// threshold+1 bands (pigeonhole), so only images sharing a band are compared
+	bands := min(threshold+1, 64)
+	parent := make([]int, len(metas))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for band := 0; band < bands; band++ {
+		lo := band * 64 / bands
+		hi := (band + 1) * 64 / bands
+		mask := uint64(1)<<(hi-lo) - 1
+		if hi-lo == 64 {
+			mask = ^uint64(0)
+		}
+		buckets := make(map[uint64][]int)
+		for i, h := range hashes {
+			key := (h >> lo) & mask
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for i := 0; i < len(bucket); i++ {
+				for j := i + 1; j < len(bucket); j++ {
+					a, b := bucket[i], bucket[j]
+					if find(a) == find(b) {
+						continue
+					}
+					if HammingDistance(hashes[a], hashes[b]) <= threshold {
+						parent[find(a)] = find(b)
+					}
+				}
+			}
+		}
+	}
+
+	groups := make(map[int][]*Meta)
+	for i, m := range metas {
+		root := find(i)
+		groups[root] = append(groups[root], m)
+	}
+
+	var clusters [][]*Meta
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		clusters = append(clusters, group)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].Path < clusters[j][0].Path })
+	return clusters
+}
@@ -0,0 +1,89 @@
+package fastdu
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage draws a pattern whose brightness varies across the image
+func testImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x-w/3, y-h/2
+			v := uint8((x*255/w + (dx*dx+dy*dy)*255/(w*w)) / 2)
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func Test_dHash(t *testing.T) {
+	orig := dHash(testImage(640, 480, false))
+
+	// thumbnail of the same picture
+	thumb := dHash(shrink(testImage(640, 480, false), 4))
+	assert.LessOrEqual(t, HammingDistance(orig, thumb), 6)
+
+	// re-encoded copy
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(640, 480, false), &jpeg.Options{Quality: 50}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.LessOrEqual(t, HammingDistance(orig, dHash(decoded)), 6)
+
+	// different picture
+	assert.Greater(t, HammingDistance(orig, dHash(testImage(640, 480, true))), 20)
+}
+
+// shrink averages factor x factor blocks of pixels
+func shrink(img image.Image, factor int) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx()/factor, b.Dy()/factor))
+	for y := 0; y < b.Dy()/factor; y++ {
+		for x := 0; x < b.Dx()/factor; x++ {
+			var r, g, bl uint32
+			for i := 0; i < factor; i++ {
+				for j := 0; j < factor; j++ {
+					pr, pg, pb, _ := img.At(x*factor+j, y*factor+i).RGBA()
+					r, g, bl = r+pr>>8, g+pg>>8, bl+pb>>8
+				}
+			}
+			n := uint32(factor * factor)
+			out.Set(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255})
+		}
+	}
+	return out
+}
+
+func TestDirCount_FindNearDuplicates(t *testing.T) {
+	d := NewDirCount("")
+	for path, phash := range map[string]string{
+		"/a/Flower 10.jpg":             "f0f0f0f0f0f0f0f0",
+		"/a/.thumbnails/Flower 10.jpg": "f0f0f0f0f0f0f0f1", // 1 bit off
+		"/b/edited.jpg":                "f0f0f0f0f0f0f0f7", // 2 bits off thumbnail
+		"/c/other.jpg":                 "0f0f0f0f0f0f0f0f",
+		"/c/video.mp4":                 "",
+	} {
+		d.Meta[path] = &Meta{Path: path, PHash: phash}
+	}
+
+	clusters := d.FindNearDuplicates(2)
+	if assert.Len(t, clusters, 1) {
+		assert.Len(t, clusters[0], 3)
+		assert.Equal(t, "/a/.thumbnails/Flower 10.jpg", clusters[0][0].Path)
+	}
+	assert.Empty(t, d.FindNearDuplicates(0))
+}
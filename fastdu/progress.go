@@ -0,0 +1,103 @@
+package fastdu
+
+import "time"
+
+// EventKind identifies the type of a progress Event
+type EventKind int
+
+const (
+	EventDirEntered    EventKind = iota // a dir is about to be read
+	EventFileProcessed                  // a file was counted
+	EventError                          // a file or dir could not be read
+	EventTick                           // periodic snapshot, see ScanOptions.ProgressInterval
+	EventDone                           // scan finished or was cancelled
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventDirEntered:
+		return "dir"
+	case EventFileProcessed:
+		return "file"
+	case EventError:
+		return "error"
+	case EventTick:
+		return "tick"
+	case EventDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// Event is a progress notification sent to a ProgressReporter; every event
+// carries the running totals of the scan
+type Event struct {
+	Kind      EventKind
+	Path      string // dir or file the event refers to, if any
+	Size      int64  // size of the processed file
+	Err       error  // set for EventError
+	Dirs      int64  // dirs entered so far
+	Files     int64  // files processed so far
+	Bytes     int64  // bytes processed so far (apparent size)
+	DiskBytes int64  // allocated bytes processed so far
+	Counters  CountersSnapshot
+	Elapsed   time.Duration
+	ETA       time.Duration // estimated time remaining; 0 when no previous totals are known
+}
+
+// ProgressReporter receives progress events during a scan. Report is called
+// concurrently from the scanning goroutines and should return quickly.
+type ProgressReporter interface {
+	Report(e Event)
+}
+
+// ProgressFunc adapts a function to a ProgressReporter
+type ProgressFunc func(e Event)
+
+// Report calls f(e)
+func (f ProgressFunc) Report(e Event) {
+	f(e)
+}
+
+// CountersSnapshot is a point in time copy of Counters
+type CountersSnapshot struct {
+	ExifErrors          uint64
+	VideoCnt            int64
+	AudioCnt            int64
+	ImageCnt            int64
+	FileSizeMismatchCnt int64
+	FilesSkipCnt        int64
+	HardLinkCnt         int64
+	SparseFileCnt       int64
+	SymlinkCnt          int64
+	BrokenLinkCnt       int64
+	FollowedLinkCnt     int64
+	CacheHitCnt         int64
+}
+
+// Snapshot returns the current values of the counters
+func (c *Counters) Snapshot() CountersSnapshot {
+	return CountersSnapshot{
+		ExifErrors:          c.ExifErrors.Load(),
+		VideoCnt:            c.VideoCnt.Load(),
+		AudioCnt:            c.AudioCnt.Load(),
+		ImageCnt:            c.ImageCnt.Load(),
+		FileSizeMismatchCnt: c.FileSizeMismatchCnt.Load(),
+		FilesSkipCnt:        c.FilesSkipCnt.Load(),
+		HardLinkCnt:         c.HardLinkCnt.Load(),
+		SparseFileCnt:       c.SparseFileCnt.Load(),
+		SymlinkCnt:          c.SymlinkCnt.Load(),
+		BrokenLinkCnt:       c.BrokenLinkCnt.Load(),
+		FollowedLinkCnt:     c.FollowedLinkCnt.Load(),
+		CacheHitCnt:         c.CacheHitCnt.Load(),
+	}
+}
+
+// eta estimates the remaining time from the fraction of work done compared
+// to the totals of a previous scan; bytes are preferred over file counts
+func eta(elapsed time.Duration, done, expected int64) time.Duration {
+	if expected <= 0 || done <= 0 || done >= expected {
+		return 0
+	}
+	return time.Duration(float64(elapsed) * float64(expected-done) / float64(done))
+}
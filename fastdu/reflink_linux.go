@@ -0,0 +1,44 @@
+package fastdu
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ioctl FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// Reflink creates dst as a copy of src that shares its data blocks, on file
+// systems that support it such as btrfs and xfs
+func Reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	out.Close()
+	if errno != 0 {
+		os.Remove(dst)
+		if errors.Is(errno, syscall.EXDEV) {
+			// extents are only shared within a file system
+			return fmt.Errorf("%w across file systems: %w", ErrReflinkUnsupported, errno)
+		}
+		if errors.Is(errno, syscall.EOPNOTSUPP) || errors.Is(errno, syscall.EINVAL) || errors.Is(errno, syscall.ENOTTY) {
+			return ErrReflinkUnsupported
+		}
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fastdu
+
+// Reflink is only supported on linux
+func Reflink(src, dst string) error {
+	return ErrReflinkUnsupported
+}
@@ -0,0 +1,460 @@
+package fastdu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultConcurrency is the number of files/dirs opened concurrently when
+// ScanOptions.Concurrency is not set
+const DefaultConcurrency = 20
+
+// ScanOptions configures a Scanner
+type ScanOptions struct {
+	Roots          []string      // dirs or files to scan
+	Concurrency    int           // max number of files/dirs opened concurrently
+	Exclude        string        // regex of files/dirs to skip in addition to the default skip files, roots included
+	Symlinks       SymlinkPolicy // how symlinks are handled; counted as links by default
+	OneFileSystem  bool          // don't descend into mount points or dirs on a different device than their root
+	PerceptualHash bool          // compute perceptual hash of images to find near duplicates
+	SizeMode       SizeMode      // size reported by the results; both sizes are always collected
+	Files          bool          // keep the counted files of every dir so that Tree lists them
+	Cache          Cache         // unchanged files found in the cache aren't read, optional
+
+	Progress         ProgressReporter // receives progress events, optional
+	ProgressInterval time.Duration    // interval of EventTick events; disabled when 0
+	ExpectedFiles    int64            // file count of a previous scan of the same roots, used for ETA
+	ExpectedBytes    int64            // byte count of a previous scan of the same roots, used for ETA
+}
+
+// Scanner traverses directory trees concurrently collecting per dir totals
+// and meta data of media files
+type Scanner struct {
+	opts    ScanOptions
+	exclude *regexp.Regexp
+	sema    chan struct{} // limits number of open files
+	dirs    atomic.Int64
+	files   atomic.Int64
+	nbytes  atomic.Int64
+	disk    atomic.Int64 // allocated bytes
+	start   time.Time
+	d       *DirCount // results of the current scan
+
+	mu      sync.Mutex
+	visited map[string]bool // resolved dirs walked when following symlinks without inode support
+	dirIDs  map[fileID]bool // dirs walked when following symlinks
+	links   map[fileID]bool // files with several hard links that were counted
+	paths   map[string]bool // real paths of files cataloged when following symlinks
+	mounts  []MountPoint    // mount points found during the current scan
+
+	mountTable map[string]mountInfo // system mount table by absolute mount point
+}
+
+// ScanResult holds the outcome of a scan
+type ScanResult struct {
+	DirCount  *DirCount    // per dir totals and meta data of media files
+	Files     int64        // number of files found; hard linked files are counted once
+	Bytes     int64        // total apparent size of files found
+	DiskBytes int64        // total allocated size of files found
+	Mounts    []MountPoint // mount points found below the roots, sorted by path
+	Start     time.Time
+	End       time.Time
+}
+
+// NewScanner returns a Scanner for the specified options
+func NewScanner(opts ScanOptions) (*Scanner, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	exclude, err := regexp.Compile(skipPattern(opts.Exclude))
+	if err != nil {
+		return nil, fmt.Errorf("exclude pattern: %w", err)
+	}
+	return &Scanner{
+		opts:    opts,
+		exclude: exclude,
+		sema:    make(chan struct{}, opts.Concurrency),
+	}, nil
+}
+
+// Progress returns the number of files and bytes found so far
+func (s *Scanner) Progress() (int64, int64) {
+	return s.files.Load(), s.nbytes.Load()
+}
+
+// Scan walks all roots and returns the collected totals and meta data.
+// When ctx is cancelled or its deadline expires the walk stops and the
+// partial result is returned along with the context error.
+func (s *Scanner) Scan(ctx context.Context) (*ScanResult, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	s.dirs.Store(0)
+	s.files.Store(0)
+	s.nbytes.Store(0)
+	s.disk.Store(0)
+	s.visited = make(map[string]bool)
+	s.dirIDs = make(map[fileID]bool)
+	s.links = make(map[fileID]bool)
+	s.paths = make(map[string]bool)
+	s.mounts = nil
+	s.mountTable = readMounts()
+	s.start = time.Now()
+	s.d = NewDirCount(s.opts.Exclude)
+	s.d.PerceptualHash = s.opts.PerceptualHash
+	s.d.Cache = s.opts.Cache
+	s.d.SizeMode = s.opts.SizeMode
+	s.d.KeepFiles = s.opts.Files
+	s.d.SetRoots(s.opts.Roots...)
+
+	res := &ScanResult{
+		DirCount: s.d,
+		Start:    s.start,
+	}
+
+	tickDone := make(chan struct{})
+	var tickWg sync.WaitGroup
+	if s.opts.Progress != nil && s.opts.ProgressInterval > 0 {
+		tickWg.Add(1)
+		go func() {
+			defer tickWg.Done()
+			tick := time.NewTicker(s.opts.ProgressInterval)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+					s.report(EventTick, "", 0, nil)
+				case <-tickDone:
+					return
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for _, root := range s.opts.Roots {
+		fInfo, err := os.Stat(root)
+		if err != nil {
+			log.Printf("%s, %v\n", root, err)
+			s.report(EventError, root, 0, err)
+			continue
+		}
+		// handle case when fdu is invoked including files as args like so: fdu *
+		if !fInfo.IsDir() {
+			s.addFile(ctx, res.DirCount, filepath.Dir(root), fInfo)
+			continue
+		}
+		// a root dir is matched like the dirs below it
+		if s.exclude.MatchString(filepath.Clean(root) + string(filepath.Separator)) {
+			continue
+		}
+		if s.opts.Symlinks == SymlinkFollow && !s.firstVisit(root, fInfo) {
+			continue
+		}
+		dev, _ := deviceID(fInfo)
+		wg.Add(1)
+		go s.walkDir(ctx, cancel, &wg, root, dev, dev, res.DirCount)
+	}
+	wg.Wait()
+	close(tickDone)
+	tickWg.Wait()
+
+	res.End = time.Now()
+	res.Files, res.Bytes = s.Progress()
+	res.DiskBytes = s.disk.Load()
+	res.Mounts = s.mounts
+	sort.Slice(res.Mounts, func(i, j int) bool { return res.Mounts[i].Path < res.Mounts[j].Path })
+	s.report(EventDone, "", 0, context.Cause(ctx))
+	return res, context.Cause(ctx)
+}
+
+// report sends an event with the running totals to the progress reporter
+func (s *Scanner) report(kind EventKind, path string, size int64, err error) {
+	if s.opts.Progress == nil {
+		return
+	}
+	e := Event{
+		Kind:      kind,
+		Path:      path,
+		Size:      size,
+		Err:       err,
+		Dirs:      s.dirs.Load(),
+		Files:     s.files.Load(),
+		Bytes:     s.nbytes.Load(),
+		DiskBytes: s.disk.Load(),
+		Counters:  s.d.counts.Snapshot(),
+		Elapsed:   time.Since(s.start),
+	}
+	if s.opts.ExpectedBytes > 0 {
+		e.ETA = eta(e.Elapsed, e.Bytes, s.opts.ExpectedBytes)
+	} else {
+		e.ETA = eta(e.Elapsed, e.Files, s.opts.ExpectedFiles)
+	}
+	s.opts.Progress.Report(e)
+}
+
+// walkDir walks dir which is on device dev below a root on device rootDev
+func (s *Scanner) walkDir(ctx context.Context, cancel context.CancelCauseFunc, wg *sync.WaitGroup,
+	dir string, rootDev, dev uint64, d *DirCount) {
+	defer wg.Done()
+
+	s.dirs.Add(1)
+	d.AddDir(dir)
+	s.report(EventDirEntered, dir, 0, nil)
+	entries, err := s.dirents(ctx, dir)
+	if err != nil {
+		if errors.Is(err, syscall.EMFILE) {
+			cancel(fmt.Errorf("%w: reduce concurrency and retry", err))
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s, %v\n", dir, err)
+		s.report(EventError, dir, 0, err)
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			subDev, ok := s.descend(path, rootDev, dev)
+			if !ok {
+				continue
+			}
+			wg.Add(1)
+			go s.walkDir(ctx, cancel, wg, path, rootDev, subDev, d)
+			continue
+		}
+
+		if entry.Type()&os.ModeSymlink != 0 {
+			s.walkSymlink(ctx, cancel, wg, dir, path, rootDev, dev, d)
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Printf("Error getting fileinfo %s: %v\n", path, err)
+			s.report(EventError, path, 0, err)
+			continue
+		}
+		s.addFile(ctx, d, dir, info)
+	}
+}
+
+// descend reports whether the sub dir of a dir on device dev should be
+// walked and returns the device of the sub dir. Mount points are recorded and
+// skipped in one file system mode.
+func (s *Scanner) descend(dir string, rootDev, dev uint64) (uint64, bool) {
+	if s.exclude.MatchString(dir + string(filepath.Separator)) {
+		return 0, false
+	}
+	fInfo, err := os.Stat(dir)
+	if err != nil {
+		return 0, false
+	}
+	subDev, ok := deviceID(fInfo)
+	if !ok {
+		subDev = dev
+	}
+	if s.opts.Symlinks == SymlinkFollow && !s.firstVisit(dir, fInfo) {
+		return 0, false
+	}
+
+	// bind mounts are only found in the mount table since they keep the device
+	m, isMount := s.mountAt(dir)
+	if !isMount && subDev == dev {
+		return subDev, true
+	}
+	skip := s.opts.OneFileSystem && (isMount || subDev != rootDev)
+	s.addMount(MountPoint{Path: dir, FSType: m.fsType, Source: m.source, Skipped: skip})
+	return subDev, !skip
+}
+
+// walkSymlink handles a symlink found in dir according to the symlink policy
+func (s *Scanner) walkSymlink(ctx context.Context, cancel context.CancelCauseFunc, wg *sync.WaitGroup,
+	dir, path string, rootDev, dev uint64, d *DirCount) {
+	if s.opts.Symlinks == SymlinkSkip {
+		return
+	}
+	d.counts.SymlinkCnt.Add(1)
+
+	target, err := os.Stat(path)
+	if err != nil {
+		d.counts.BrokenLinkCnt.Add(1)
+		s.report(EventError, path, 0, err)
+		if s.opts.Symlinks == SymlinkFollow {
+			return
+		}
+	}
+
+	switch {
+	case s.opts.Symlinks == SymlinkCount || err != nil:
+		info, err := os.Lstat(path)
+		if err != nil {
+			log.Printf("Error getting fileinfo %s: %v\n", path, err)
+			s.report(EventError, path, 0, err)
+			return
+		}
+		s.addFile(ctx, d, dir, info)
+	case target.IsDir():
+		if subDev, ok := s.descend(path, rootDev, dev); ok {
+			wg.Add(1)
+			go s.walkDir(ctx, cancel, wg, path, rootDev, subDev, d)
+		}
+	default:
+		s.addFile(ctx, d, dir, target)
+	}
+}
+
+// firstVisit records a dir walked while following symlinks and reports
+// whether it was seen for the first time; this prevents symlink cycles and
+// walking the same dir twice
+func (s *Scanner) firstVisit(dir string, fInfo os.FileInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := statOf(fInfo); ok {
+		id := fileID{st.dev, st.ino}
+		if s.dirIDs[id] {
+			return false
+		}
+		s.dirIDs[id] = true
+		return true
+	}
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	if s.visited[resolved] {
+		return false
+	}
+	s.visited[resolved] = true
+	return true
+}
+
+// firstPath records the real path of a file found while following symlinks
+// and reports whether it was seen for the first time
+func (s *Scanner) firstPath(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paths[path] {
+		return false
+	}
+	s.paths[path] = true
+	return true
+}
+
+// namedInfo is the file info of a symlink target under the name of the
+// target
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string {
+	return i.name
+}
+
+// firstLink records a file with several hard links and reports whether it
+// was seen for the first time; each inode is only counted once
+func (s *Scanner) firstLink(id fileID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.links[id] {
+		return false
+	}
+	s.links[id] = true
+	return true
+}
+
+func (s *Scanner) addFile(ctx context.Context, d *DirCount, dir string, info os.FileInfo) {
+	if s.exclude.MatchString(filepath.Join(dir, info.Name())) {
+		d.counts.FilesSkipCnt.Add(1)
+		return
+	}
+
+	select {
+	case s.sema <- struct{}{}: // acquire token
+	case <-ctx.Done():
+		return
+	}
+	defer func() {
+		<-s.sema // release token
+	}()
+
+	// files reached through symlinks are cataloged by their real path, once;
+	// their size is counted in the dir they were found in
+	recordDir, recordInfo := dir, info
+	if s.opts.Symlinks == SymlinkFollow {
+		path := filepath.Join(dir, info.Name())
+		if real, err := filepath.EvalSymlinks(path); err == nil && real != path {
+			recordDir, recordInfo = filepath.Dir(real), namedInfo{info, filepath.Base(real)}
+		}
+		if !s.firstPath(filepath.Join(recordDir, recordInfo.Name())) {
+			d.counts.FollowedLinkCnt.Add(1)
+			return
+		}
+	}
+
+	// the size of a file is counted once, but the path of every hard link is
+	// cataloged
+	st, ok := statOf(info)
+	if ok && st.nlink > 1 && !s.firstLink(fileID{st.dev, st.ino}) {
+		d.counts.HardLinkCnt.Add(1)
+		if info.Mode()&os.ModeSymlink == 0 {
+			d.AddFile(recordDir, recordInfo)
+		}
+		return
+	}
+	disk := info.Size()
+	if ok {
+		disk = st.diskSize
+		if disk < info.Size() {
+			d.counts.SparseFileCnt.Add(1)
+		}
+	}
+
+	// a file given as root is an entry of its own; as a file of its dir its
+	// size would be rolled up to the top of the file system
+	usage := dir
+	if d.isRoot(filepath.Join(dir, info.Name())) {
+		usage = filepath.Join(dir, info.Name())
+	}
+	d.IncUsage(usage, info.Size(), disk)
+	if d.KeepFiles {
+		d.keepFile(dir, info, disk)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		d.AddFile(recordDir, recordInfo)
+	}
+	s.files.Add(1)
+	s.nbytes.Add(info.Size())
+	s.disk.Add(disk)
+	s.report(EventFileProcessed, filepath.Join(dir, info.Name()), info.Size(), nil)
+}
+
+func (s *Scanner) dirents(ctx context.Context, dir string) ([]os.DirEntry, error) {
+	select {
+	case s.sema <- struct{}{}: // acquire token
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-s.sema // release token
+	}()
+
+	return os.ReadDir(dir)
+}
@@ -0,0 +1,250 @@
+package fastdu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTree creates the files relative to dir
+func writeTree(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanner_Scan(t *testing.T) {
+	png, err := os.ReadFile("../testdata/Thumb/dont_skip.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a/1.png":          png,
+		"a/b/2.png":        png,
+		"a/notes.txt":      []byte("hello"),
+		"a/empty.txt":      {},
+		"Thumbs/3.png":     png, // default skip pattern
+		"excluded/4.png":   png,
+		"a/excluded/5.png": png,
+	})
+
+	s, err := NewScanner(ScanOptions{Roots: []string{dir}, Exclude: "/excluded/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Scan(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), res.Files)
+	assert.Equal(t, int64(2*len(png)+5), res.Bytes)
+	assert.Len(t, res.DirCount.Meta, 2)
+	assert.Contains(t, res.DirCount.Meta, filepath.Join(dir, "a/b/2.png"))
+	// diffs compare every file, not only media files, and empty files too
+	files := res.DirCount.FileStates()
+	if assert.Len(t, files, 4) {
+		assert.Equal(t, filepath.Join(dir, "a/empty.txt"), files[2].Path)
+		assert.Zero(t, files[2].Size)
+		assert.Equal(t, filepath.Join(dir, "a/notes.txt"), files[3].Path)
+		assert.False(t, files[3].Media)
+		assert.True(t, files[0].Media)
+	}
+
+	_, err = NewScanner(ScanOptions{Exclude: "("})
+	assert.Error(t, err)
+}
+
+// TestScanner_ScanFileRoot scans a file and dirs given as roots: the file is
+// an entry of its own, and excluded root dirs are skipped
+func TestScanner_ScanFileRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a.txt":          []byte("hello"),
+		"b/b.txt":        []byte("hi"),
+		"excluded/c.txt": []byte("hey"),
+	})
+	file, root, excluded := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b"), filepath.Join(dir, "excluded")
+
+	s, err := NewScanner(ScanOptions{Roots: []string{file, root, excluded}, Exclude: "/excluded/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Scan(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), res.Files)
+	stats := res.DirCount.DirStats()
+	assert.Equal(t, DirStat{Size: 5, DiskSize: stats[file].DiskSize, Files: 1}, stats[file])
+	assert.Equal(t, int64(2), stats[root].Size)
+	assert.NotContains(t, stats, excluded)
+	assert.NotContains(t, stats, dir)
+	assert.NotContains(t, stats, "/")
+}
+
+func TestScanner_ScanCancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a/b/c.txt": []byte("hello")})
+
+	s, err := NewScanner(ScanOptions{Roots: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := s.Scan(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), res.Files)
+}
+
+func TestScanner_Progress(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a/1.txt":   []byte("hello"),
+		"a/b/2.txt": []byte("world"),
+	})
+
+	var mu sync.Mutex
+	kinds := map[EventKind]int{}
+	var last Event
+	s, err := NewScanner(ScanOptions{
+		Roots: []string{dir},
+		Progress: ProgressFunc(func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			kinds[e.Kind]++
+			last = e
+		}),
+		ExpectedBytes: 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 3, kinds[EventDirEntered])
+	assert.Equal(t, 2, kinds[EventFileProcessed])
+	assert.Equal(t, 1, kinds[EventDone])
+	assert.Equal(t, EventDone, last.Kind)
+	assert.Equal(t, int64(10), last.Bytes)
+	assert.Equal(t, int64(3), last.Dirs)
+}
+
+func Test_eta(t *testing.T) {
+	assert.Equal(t, 30*time.Second, eta(10*time.Second, 25, 100))
+	assert.Equal(t, time.Duration(0), eta(10*time.Second, 25, 0))
+	assert.Equal(t, time.Duration(0), eta(10*time.Second, 120, 100))
+}
+
+func TestScanner_DiskUsage(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a/data": make([]byte, 8192)})
+	if err := os.Link(filepath.Join(dir, "a/data"), filepath.Join(dir, "a/link")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	sparse, err := os.Create(filepath.Join(dir, "sparse.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse.Truncate(1 << 30)
+	sparse.Close()
+
+	s, err := NewScanner(ScanOptions{Roots: []string{dir}, SizeMode: DiskUsage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hard link is counted once
+	assert.Equal(t, int64(2), res.Files)
+	assert.Equal(t, int64(8192+1<<30), res.Bytes)
+	assert.Less(t, res.DiskBytes, int64(1<<20))
+	snap := res.DirCount.CountersSnapshot()
+	assert.Equal(t, int64(1), snap.HardLinkCnt)
+	assert.Equal(t, int64(1), snap.SparseFileCnt)
+	assert.Equal(t, res.DiskBytes, res.DirCount.GetTop()[dir])
+	// but both links are cataloged
+	var paths []string
+	for _, f := range res.DirCount.FileStates() {
+		paths = append(paths, f.Path)
+	}
+	assert.Contains(t, paths, filepath.Join(dir, "a/data"))
+	assert.Contains(t, paths, filepath.Join(dir, "a/link"))
+}
+
+func TestScanner_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{"a/data": make([]byte, 100)})
+	links := map[string]string{
+		"a/loop": dir,            // cycle back to the root
+		"b":      "a",            // linked dir
+		"f":      "a/data",       // linked file
+		"broken": "doesnotexist", // dangling link
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+	}
+
+	tests := []struct {
+		policy SymlinkPolicy
+		files  int64
+		links  int64
+	}{
+		{SymlinkSkip, 1, 0},
+		{SymlinkCount, 5, 4},
+		// data is counted once however it is reached, and the cycle is not walked
+		{SymlinkFollow, 1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			s, err := NewScanner(ScanOptions{Roots: []string{dir}, Symlinks: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := s.Scan(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.files, res.Files)
+			snap := res.DirCount.CountersSnapshot()
+			assert.Equal(t, tt.links, snap.SymlinkCnt)
+			if tt.policy != SymlinkSkip {
+				assert.Equal(t, int64(1), snap.BrokenLinkCnt)
+			}
+			if tt.policy == SymlinkFollow {
+				assert.Equal(t, int64(100), res.Bytes)
+				// data is cataloged by its real path, whichever way was walked first
+				files := res.DirCount.FileStates()
+				if assert.Len(t, files, 1) {
+					real, _ := filepath.EvalSymlinks(filepath.Join(dir, "a/data"))
+					assert.Equal(t, real, files[0].Path)
+				}
+				assert.Positive(t, snap.FollowedLinkCnt)
+				assert.Zero(t, snap.HardLinkCnt)
+			}
+		})
+	}
+}
+
+func TestSymlinkPolicy_Set(t *testing.T) {
+	var p SymlinkPolicy
+	assert.NoError(t, p.Set("follow"))
+	assert.Equal(t, SymlinkFollow, p)
+	assert.Error(t, p.Set("bogus"))
+}
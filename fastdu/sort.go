@@ -37,6 +37,13 @@ func (s SortedFileBySize) Len() int           { return len(s) }
 func (s SortedFileBySize) Less(i, j int) bool { return s[i].Size < s[j].Size }
 func (s SortedFileBySize) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
+// SortedFileByDiskSize sorts files by allocated size held in metadata
+type SortedFileByDiskSize []*Meta
+
+func (s SortedFileByDiskSize) Len() int           { return len(s) }
+func (s SortedFileByDiskSize) Less(i, j int) bool { return s[i].DiskSize < s[j].DiskSize }
+func (s SortedFileByDiskSize) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // SortedKeys takes a dictionary and returns a sorted slice of keys
 func SortedKeys(m map[string]int64) []string {
 	sm := &sortedMap{}
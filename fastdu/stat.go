@@ -0,0 +1,31 @@
+package fastdu
+
+import "os"
+
+// SizeMode selects which size of a file is reported
+type SizeMode int
+
+const (
+	ApparentSize SizeMode = iota // file length, like du --apparent-size
+	DiskUsage                    // allocated blocks, like du
+)
+
+// fileStat holds the platform specific stat info used for disk usage
+type fileStat struct {
+	dev      uint64
+	ino      uint64
+	nlink    uint64
+	diskSize int64 // allocated bytes
+}
+
+// fileID identifies a file independent of its path(s)
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// deviceID returns the id of the device holding the file
+func deviceID(fInfo os.FileInfo) (uint64, bool) {
+	st, ok := statOf(fInfo)
+	return st.dev, ok
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fastdu
+
+import "os"
+
+// statOf is not supported on this platform
+func statOf(fInfo os.FileInfo) (fileStat, bool) {
+	return fileStat{}, false
+}
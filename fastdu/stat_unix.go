@@ -0,0 +1,22 @@
+//go:build unix
+
+package fastdu
+
+import (
+	"os"
+	"syscall"
+)
+
+// statOf returns the device, inode, link count and allocated size of the file
+func statOf(fInfo os.FileInfo) (fileStat, bool) {
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		dev:      uint64(st.Dev),
+		ino:      uint64(st.Ino),
+		nlink:    uint64(st.Nlink),
+		diskSize: int64(st.Blocks) * 512, // st_blocks is always in 512 byte units
+	}, true
+}
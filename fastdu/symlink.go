@@ -0,0 +1,32 @@
+package fastdu
+
+import "fmt"
+
+// SymlinkPolicy selects how symlinks found during a scan are handled
+type SymlinkPolicy int
+
+const (
+	SymlinkCount  SymlinkPolicy = iota // count the size of the link itself, like du
+	SymlinkSkip                        // ignore symlinks
+	SymlinkFollow                      // count link targets and descend into linked dirs, like du -L
+)
+
+var symlinkPolicies = [...]string{"count", "skip", "follow"}
+
+func (p SymlinkPolicy) String() string {
+	if p < 0 || int(p) >= len(symlinkPolicies) {
+		return "unknown"
+	}
+	return symlinkPolicies[p]
+}
+
+// Set parses the policy name; it implements flag.Value
+func (p *SymlinkPolicy) Set(s string) error {
+	for i, name := range symlinkPolicies {
+		if s == name {
+			*p = SymlinkPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symlink policy %q, expecting one of %v", s, symlinkPolicies)
+}
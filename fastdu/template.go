@@ -0,0 +1,138 @@
+package fastdu
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultTemplate is the date tree layout of replicate: YYYY/MM/DD/name
+const DefaultTemplate = "{year}/{month:02}/{day:02}/{file}"
+
+// TemplateFields are the fields available to templates
+var TemplateFields = []string{
+	"year", "month", "day", "hour", "minute", "second",
+	"date", // YYYYMMDD
+	"time", // HHMMSS
+	"file", // base file name
+	"name", // base file name without extension
+	"ext",  // extension without the dot, as detected from the content
+	"mime_type", "mime_subtype",
+	"camera_make", "camera_model", "lens", "artist", // from exif
+	"date_source", // exif, create, video, filename or mtime
+	"seq",         // 1, 2, ... for files that would otherwise get the same path
+}
+
+// Template renders destination paths of media files from fields, e.g.
+// "{year}/{month:02}/{camera_model}/{date}_{time}_{seq}.{ext}". A field may
+// be followed by a width; numbers are padded with zeros if the width starts
+// with 0 and strings are cut to the width
+type Template struct {
+	text  string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string // empty for literals
+	width   int
+	zero    bool
+}
+
+// ParseTemplate parses a template; unknown fields are an error
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{text: text}
+	for rest := text; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: missing } after %q", text, rest[open:])
+		}
+		spec := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+
+		p := templatePart{field: spec}
+		if name, width, ok := strings.Cut(spec, ":"); ok {
+			w, err := strconv.Atoi(width)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("template %q: invalid width %q of field %s", text, width, name)
+			}
+			p.field, p.width, p.zero = name, w, strings.HasPrefix(width, "0")
+		}
+		if !slices.Contains(TemplateFields, p.field) {
+			return nil, fmt.Errorf("template %q: unknown field %q, expecting one of %v", text, p.field, TemplateFields)
+		}
+		t.parts = append(t.parts, p)
+	}
+	return t, nil
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+// Uses reports whether the template contains the field
+func (t *Template) Uses(field string) bool {
+	for _, p := range t.parts {
+		if p.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute renders the template; values are int or string. Missing and empty
+// values render as "unknown". Values come from file metadata, so path
+// separators and control characters in them are replaced, and values of
+// only dots such as ".." are escaped, so that a value can't add or leave
+// dirs. Widths of strings count characters.
+func (t *Template) Execute(values map[string]any) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		var s string
+		switch v := values[p.field].(type) {
+		case int:
+			if p.zero {
+				s = fmt.Sprintf("%0*d", p.width, v)
+			} else {
+				s = strconv.Itoa(v)
+			}
+		case string:
+			s = strings.TrimSpace(strings.ToValidUTF8(v, "_"))
+			if r := []rune(s); p.width > 0 && len(r) > p.width {
+				s = string(r[:p.width])
+			}
+		}
+		if s == "" {
+			s = "unknown"
+		}
+		b.WriteString(cleanValue(s))
+	}
+	return b.String()
+}
+
+// cleanValue makes a value safe to use in a path
+func cleanValue(s string) string {
+	if strings.Trim(s, ".") == "" {
+		return strings.Repeat("_", len(s))
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
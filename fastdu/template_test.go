@@ -0,0 +1,65 @@
+package fastdu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate_Execute(t *testing.T) {
+	values := map[string]any{
+		"year": 2019, "month": 3, "day": 12, "date": "20190312", "time": "153012",
+		"file": "IMG_1.JPG", "ext": "jpg", "camera_model": "Pixel 3/XL", "seq": 7,
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{DefaultTemplate, "2019/03/12/IMG_1.JPG"},
+		{"{year}/{month:02}/{camera_model}/{date}_{time}_{seq:03}.{ext}", "2019/03/Pixel 3_XL/20190312_153012_007.jpg"},
+		{"{year}/{lens}/{camera_model:5}", "2019/unknown/Pixel"},
+		{"{month:2}-{seq}", "3-7"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tt.want, tmpl.Execute(values))
+		}
+	}
+}
+
+// TestTemplate_ExecuteUntrusted renders values of crafted exif data, which
+// must not leave the destination or produce invalid names
+func TestTemplate_ExecuteUntrusted(t *testing.T) {
+	tests := []struct {
+		template string
+		model    string
+		want     string
+	}{
+		{"{camera_model}/{camera_model}/{file}", "..", "__/__/a.jpg"},
+		{"{camera_model}/{file}", ".", "_/a.jpg"},
+		{"{camera_model}/{file}", "../..", ".._../a.jpg"},
+		{"{camera_model}/{file}", "a\x00b\nc", "a_b_c/a.jpg"},
+		{"{camera_model}/{file}", "v1.2", "v1.2/a.jpg"},
+		{"{camera_model:3}/{file}", "Größe", "Grö/a.jpg"},
+		{"{camera_model:2}/{file}", "\xffab", "_a/a.jpg"},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if assert.NoError(t, err) {
+			assert.Equal(t, tt.want, tmpl.Execute(map[string]any{"camera_model": tt.model, "file": "a.jpg"}), tt.model)
+		}
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("{year}/{seq}")
+	assert.NoError(t, err)
+	assert.True(t, tmpl.Uses("seq"))
+	assert.False(t, tmpl.Uses("month"))
+
+	for _, bad := range []string{"{year", "{colour}", "{month:x}", "{month:0}"} {
+		_, err := ParseTemplate(bad)
+		assert.Error(t, err, bad)
+	}
+}
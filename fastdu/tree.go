@@ -0,0 +1,146 @@
+package fastdu
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DirNode is a dir in the tree of scan results
+type DirNode struct {
+	Name     string
+	Path     string
+	Size     int64 // cumulative size of files below this dir as selected by SizeMode
+	Items    int64 // cumulative number of files and dirs below this dir
+	Parent   *DirNode
+	Children []*DirNode
+	Files    []FileNode // files counted directly in this dir; kept only if DirCount.KeepFiles is set
+}
+
+// FileNode is a file counted by the scan
+type FileNode struct {
+	Name    string
+	Path    string
+	Size    int64 // size as selected by SizeMode
+	Modtime time.Time
+}
+
+// keepFile records a file counted in dir so that Tree lists it
+func (d *DirCount) keepFile(dir string, fInfo os.FileInfo, diskSize int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.files == nil {
+		d.files = make(map[string][]FileNode)
+	}
+	dir = filepath.Clean(dir)
+	size := fInfo.Size()
+	if d.SizeMode == DiskUsage {
+		size = diskSize
+	}
+	d.files[dir] = append(d.files[dir], FileNode{Name: fInfo.Name(), Path: filepath.Join(dir, fInfo.Name()),
+		Size: size, Modtime: fInfo.ModTime()})
+}
+
+// Tree arranges the dirs collected below the roots as a tree; the scan
+// roots are used when no roots are given. The root node is returned when a
+// single root is given, otherwise the returned node has no path and holds the
+// roots as children.
+func (d *DirCount) Tree(roots ...string) *DirNode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(roots) == 0 {
+		for root := range d.roots {
+			roots = append(roots, root)
+		}
+		slices.Sort(roots)
+	}
+
+	top := &DirNode{}
+	nodes := make(map[string]*DirNode)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if _, ok := nodes[root]; ok {
+			continue
+		}
+		n := d.node(root)
+		n.Parent = top
+		nodes[root] = n
+		top.Children = append(top.Children, n)
+		top.Size += n.Size
+		top.Items += n.Items
+	}
+
+	// attach every dir below a root to its parent
+	var attach func(dir string) *DirNode
+	attach = func(dir string) *DirNode {
+		if n, ok := nodes[dir]; ok {
+			return n
+		}
+		parent := d.parent(dir)
+		if parent == "" {
+			return nil
+		}
+		p := attach(parent)
+		if p == nil {
+			return nil
+		}
+		n := d.node(dir)
+		n.Parent = p
+		p.Children = append(p.Children, n)
+		nodes[dir] = n
+		return n
+	}
+	for dir := range d.dirs {
+		attach(dir)
+	}
+
+	if len(top.Children) == 1 {
+		root := top.Children[0]
+		root.Parent = nil
+		return root
+	}
+	return top
+}
+
+// node returns a node holding the totals of dir
+func (d *DirCount) node(dir string) *DirNode {
+	n := &DirNode{Name: filepath.Base(dir), Path: dir, Files: slices.Clone(d.files[dir])}
+	if st, ok := d.dirs[dir]; ok {
+		n.Size = d.sizeOf(st)
+		n.Items = st.Files + st.Dirs
+	}
+	return n
+}
+
+// Adjust adds size and items to the node and all of its ancestors
+func (n *DirNode) Adjust(size, items int64) {
+	for ; n != nil; n = n.Parent {
+		n.Size += size
+		n.Items += items
+	}
+}
+
+// Detach removes the node from its parent and subtracts its totals from all
+// of its ancestors
+func (n *DirNode) Detach() {
+	if n.Parent == nil {
+		return
+	}
+	n.Parent.Children = slices.DeleteFunc(n.Parent.Children, func(c *DirNode) bool { return c == n })
+	n.Parent.Adjust(-n.Size, -(n.Items + 1))
+	n.Parent = nil
+}
+
+// RemoveFile removes the file at path from the node and subtracts its size
+// from the node and all of its ancestors
+func (n *DirNode) RemoveFile(path string) {
+	i := slices.IndexFunc(n.Files, func(f FileNode) bool { return f.Path == path })
+	if i < 0 {
+		return
+	}
+	size := n.Files[i].Size
+	n.Files = slices.Delete(n.Files, i, i+1)
+	n.Adjust(-size, -1)
+}
@@ -0,0 +1,85 @@
+package fastdu
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirCount_Tree(t *testing.T) {
+	d := NewDirCount("")
+	d.Inc("/r", 1)
+	d.Inc("/r/a", 10)
+	d.Inc("/r/a", 10)
+	d.Inc("/r/a/b/c", 100)
+	d.Inc("/r/d", 1000)
+
+	root := d.Tree("/r/")
+	assert.Equal(t, "/r", root.Path)
+	assert.Nil(t, root.Parent)
+	assert.Equal(t, int64(1121), root.Size)
+	// 5 files and 4 dirs (a, a/b, a/b/c, d)
+	assert.Equal(t, int64(9), root.Items)
+
+	var a *DirNode
+	for _, c := range root.Children {
+		if c.Name == "a" {
+			a = c
+		}
+	}
+	if assert.NotNil(t, a) {
+		assert.Equal(t, int64(120), a.Size)
+		assert.Equal(t, int64(5), a.Items)
+
+		b := a.Children[0]
+		b.Detach()
+		assert.Empty(t, a.Children)
+		assert.Equal(t, int64(20), a.Size)
+		assert.Equal(t, int64(2), a.Items)
+		assert.Equal(t, int64(1021), root.Size)
+		assert.Equal(t, int64(6), root.Items)
+	}
+
+	top := d.Tree("/r/a", "/r/d")
+	assert.Len(t, top.Children, 2)
+	assert.Equal(t, int64(1120), top.Size) // "/r" files are outside of the roots
+}
+
+func TestDirCount_TreeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string][]byte{
+		"a/1.txt": []byte("hello"),
+		"a/2.txt": []byte("hi"),
+		"b.txt":   []byte("world"),
+	})
+	s, err := NewScanner(ScanOptions{Roots: []string{dir}, Files: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, map[string][]byte{"a/3.txt": []byte("not scanned")})
+
+	root := res.DirCount.Tree()
+	if assert.Len(t, root.Files, 1) {
+		assert.Equal(t, FileNode{Name: "b.txt", Path: filepath.Join(dir, "b.txt"), Size: 5,
+			Modtime: root.Files[0].Modtime}, root.Files[0])
+	}
+	a := root.Children[0]
+	assert.Len(t, a.Files, 2) // files created after the scan aren't listed
+
+	a.RemoveFile(filepath.Join(dir, "a/1.txt"))
+	assert.Len(t, a.Files, 1)
+	assert.Equal(t, int64(2), a.Size)
+	assert.Equal(t, int64(1), a.Items)
+	assert.Equal(t, int64(7), root.Size)
+	a.RemoveFile(filepath.Join(dir, "a/3.txt"))
+	assert.Equal(t, int64(7), root.Size)
+
+	// files are only kept on request
+	assert.Empty(t, NewDirCount("").Tree("/r").Files)
+}
@@ -0,0 +1,327 @@
+package fastdu
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// mp4Epoch is the origin of the times of ISO base media (MP4, MOV) files
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// boxHeader reads the size and type of an ISO base media box at the current
+// offset of r; size includes the header, whose length is returned as well
+func boxHeader(r io.Reader) (size int64, typ string, hdrLen int64, err error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, "", 0, err
+	}
+	size, typ, hdrLen = int64(binary.BigEndian.Uint32(hdr[:4])), string(hdr[4:]), 8
+	if size == 1 {
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, "", 0, err
+		}
+		size, hdrLen = int64(binary.BigEndian.Uint64(ext[:])), 16
+	}
+	return size, typ, hdrLen, nil
+}
+
+// findBox seeks r to the body of the first box of type typ between start and
+// end, and returns the end offset of the box; end < 0 means end of file
+func findBox(r io.ReadSeeker, start, end int64, typ string) (int64, bool) {
+	for off := start; end < 0 || off+8 <= end; {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return 0, false
+		}
+		size, t, hdrLen, err := boxHeader(r)
+		if err != nil {
+			return 0, false
+		}
+		boxEnd := off + size
+		if size == 0 {
+			// box extends to the end of the file
+			if end < 0 {
+				boxEnd = 1<<63 - 1
+			} else {
+				boxEnd = end
+			}
+		} else if size < hdrLen {
+			return 0, false
+		}
+		if t == typ {
+			return boxEnd, true
+		}
+		off = boxEnd
+	}
+	return 0, false
+}
+
+// isBoxType reports whether typ is the type of a top level box that MP4 and
+// MOV files start with
+func isBoxType(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// eachBox calls fn with the type and body of the boxes of b; a truncated box
+// ends the iteration
+func eachBox(b []byte, fn func(typ string, body []byte)) {
+	for len(b) >= 8 {
+		size, hdrLen := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		typ := string(b[4:8])
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return
+			}
+			size, hdrLen = binary.BigEndian.Uint64(b[8:16]), 16
+		}
+		if size < hdrLen || size > uint64(len(b)) {
+			return
+		}
+		fn(typ, b[hdrLen:size])
+		b = b[size:]
+	}
+}
+
+// maxBoxSize limits the boxes of the movie box that are read into memory;
+// headers and metadata take a few KB. The sample tables, which make up most
+// of the movie box of long videos, are never read.
+const maxBoxSize = 1 << 20
+
+// mp4Info reads the movie box of an MP4 or MOV file: the creation time and
+// duration of the movie header (mvhd), the frame size of the first video
+// track (tkhd), and the location (©xyz) and tags of the user data (udta) and
+// metadata (meta) boxes. Cameras record the creation time in UTC and it is
+// returned in local time like exif dates. Only the boxes that are used are
+// read.
+func mp4Info(r io.ReadSeeker) MediaInfo {
+	var info MediaInfo
+	moovEnd, ok := findBox(r, 0, -1, "moov")
+	if !ok {
+		return info
+	}
+	moovStart, _ := r.Seek(0, io.SeekCurrent)
+	walkBoxes(r, moovStart, moovEnd, func(typ string, start, body, end int64) {
+		switch typ {
+		case "mvhd":
+			if b, ok := readBox(r, start, end); ok {
+				eachBox(b, func(_ string, body []byte) { info.Created, info.Duration = movieHeader(body) })
+			}
+		case "trak":
+			if info.Width != 0 {
+				return
+			}
+			// only the track header; the media box holds the sample tables
+			walkBoxes(r, body, end, func(typ string, start, _, end int64) {
+				if b, ok := readBox(r, start, end); ok && typ == "tkhd" {
+					info.Width, info.Height = trackSize(b)
+				}
+			})
+		case "udta", "meta":
+			if b, ok := readBox(r, start, end); ok {
+				eachBox(b, func(typ string, body []byte) {
+					if typ == "udta" {
+						userData(body, &info)
+					} else {
+						metadata(body, &info)
+					}
+				})
+			}
+		}
+	})
+	return info
+}
+
+// walkBoxes calls fn with the type and the offsets of the start, the body and
+// the end of the boxes of r between start and end
+func walkBoxes(r io.ReadSeeker, start, end int64, fn func(typ string, start, body, end int64)) {
+	for off := start; off+8 <= end; {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return
+		}
+		size, typ, hdrLen, err := boxHeader(r)
+		if err != nil {
+			return
+		}
+		boxEnd := off + size
+		if size == 0 {
+			boxEnd = end
+		} else if size < hdrLen || boxEnd > end {
+			return
+		}
+		fn(typ, off, off+hdrLen, boxEnd)
+		off = boxEnd
+	}
+}
+
+// readBox reads the box, including its header, between start and end unless
+// it is larger than maxBoxSize
+func readBox(r io.ReadSeeker, start, end int64) ([]byte, bool) {
+	if end-start > maxBoxSize {
+		return nil, false
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, false
+	}
+	b := make([]byte, end-start)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// movieHeader returns the creation time and duration of an mvhd box
+func movieHeader(b []byte) (time.Time, time.Duration) {
+	var secs, scale, dur uint64
+	switch {
+	case len(b) >= 32 && b[0] == 1: // 64 bit times
+		secs = binary.BigEndian.Uint64(b[4:])
+		scale, dur = uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:])
+		if dur == 1<<64-1 {
+			dur = 0 // unknown
+		}
+	case len(b) >= 20 && b[0] == 0:
+		secs = uint64(binary.BigEndian.Uint32(b[4:]))
+		scale, dur = uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:]))
+		if dur == 1<<32-1 {
+			dur = 0
+		}
+	default:
+		return time.Time{}, 0
+	}
+	var created time.Time
+	// files written without a clock have a zero creation time
+	if secs != 0 && secs <= 1<<40 {
+		created = mp4Epoch.Add(time.Duration(secs) * time.Second).Local()
+	}
+	var d time.Duration
+	if scale > 0 {
+		d = time.Duration(float64(dur) / float64(scale) * float64(time.Second))
+	}
+	return created, d
+}
+
+// trackSize returns the frame size of a video track from the boxes of the
+// track, or its header box; it is zero for other tracks. Portrait videos of
+// phones are stored in landscape with a rotation matrix, so the size is
+// swapped for rotations by 90 degrees.
+func trackSize(trak []byte) (int, int) {
+	var w, h int
+	eachBox(trak, func(typ string, b []byte) {
+		if typ != "tkhd" {
+			return
+		}
+		off := 40 // matrix of version 0 headers
+		if len(b) > 0 && b[0] == 1 {
+			off = 52
+		}
+		if len(b) < off+44 {
+			return
+		}
+		a, c := int32(binary.BigEndian.Uint32(b[off:])), int32(binary.BigEndian.Uint32(b[off+4:]))
+		w = int(binary.BigEndian.Uint32(b[off+36:]) >> 16)
+		h = int(binary.BigEndian.Uint32(b[off+40:]) >> 16)
+		if a == 0 && (c == 1<<16 || c == -1<<16) {
+			w, h = h, w
+		}
+	})
+	return w, h
+}
+
+// userData reads the QuickTime text atoms of a udta box, such as ©xyz and
+// ©nam, and its metadata box
+func userData(udta []byte, info *MediaInfo) {
+	eachBox(udta, func(typ string, b []byte) {
+		if typ == "meta" {
+			metadata(b, info)
+			return
+		}
+		if !strings.HasPrefix(typ, "\xa9") || len(b) < 4 {
+			return
+		}
+		// text atoms start with the length of the text and a language code
+		n := int(binary.BigEndian.Uint16(b))
+		if n > len(b)-4 {
+			n = len(b) - 4
+		}
+		text := string(b[4 : 4+n])
+		if typ == "\xa9xyz" {
+			if info.Location == nil {
+				info.Location, _ = parseISO6709(text)
+			}
+			return
+		}
+		info.Tags.set(typ, text)
+	})
+}
+
+// quickTimeKeys prefixes the names of metadata keys written by Apple devices
+const quickTimeKeys = "com.apple.quicktime."
+
+// metadata reads the item list (ilst) of a meta box; items are named by
+// their type, such as ©nam, or by a key of the keys box, such as
+// com.apple.quicktime.location.ISO6709
+func metadata(meta []byte, info *MediaInfo) {
+	// the meta box of MP4 files has a version, the one of MOV files doesn't
+	if len(meta) >= 4 && binary.BigEndian.Uint32(meta) == 0 {
+		meta = meta[4:]
+	}
+	var keys []string
+	eachBox(meta, func(typ string, b []byte) {
+		switch typ {
+		case "keys":
+			if len(b) < 8 {
+				return
+			}
+			// version, count and entries of size, namespace and name
+			for b = b[8:]; len(b) >= 8; {
+				n := int(binary.BigEndian.Uint32(b))
+				if n < 8 || n > len(b) {
+					return
+				}
+				keys = append(keys, string(b[8:n]))
+				b = b[n:]
+			}
+		case "ilst":
+			eachBox(b, func(name string, item []byte) {
+				if i := int(binary.BigEndian.Uint32([]byte(name))); i >= 1 && i <= len(keys) {
+					name = keys[i-1]
+				}
+				eachBox(item, func(typ string, data []byte) {
+					// type 1 is UTF-8 text, followed by a locale
+					if typ != "data" || len(data) < 8 || binary.BigEndian.Uint32(data) != 1 {
+						return
+					}
+					metadataItem(name, string(data[8:]), info)
+				})
+			})
+		}
+	})
+}
+
+func metadataItem(name, value string, info *MediaInfo) {
+	switch name {
+	case quickTimeKeys + "location.ISO6709":
+		if info.Location == nil {
+			info.Location, _ = parseISO6709(value)
+		}
+	case quickTimeKeys + "creationdate":
+		// local time with its offset; the movie header has the same time in
+		// UTC
+		if t, err := time.Parse("2006-01-02T15:04:05-0700", value); err == nil && info.Created.IsZero() {
+			info.Created = t.Local()
+		}
+	case quickTimeKeys + "content.identifier":
+		info.ContentID = value
+	default:
+		info.Tags.set(strings.TrimPrefix(name, quickTimeKeys), value)
+	}
+}
@@ -0,0 +1,118 @@
+package fastdu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// box returns an ISO base media box of type typ with the specified body
+func box(typ string, body ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(bytes.Join(body, nil))))
+	b = append(b, typ...)
+	return append(b, bytes.Join(body, nil)...)
+}
+
+// fullBox returns a box with a version and flags
+func fullBox(typ string, version byte, body ...[]byte) []byte {
+	return box(typ, append([][]byte{{version, 0, 0, 0}}, body...)...)
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// tkhd returns a version 0 track header with the matrix of a rotation by
+// 90 degrees if rotated
+func tkhd(width, height uint32, rotated bool) []byte {
+	matrix := make([]byte, 36)
+	if rotated {
+		copy(matrix[4:], u32(1<<16))
+	} else {
+		copy(matrix, u32(1<<16))
+	}
+	return fullBox("tkhd", 0, make([]byte, 36), matrix, u32(width<<16), u32(height<<16))
+}
+
+// item returns a metadata item with UTF-8 text
+func item(typ string, value string) []byte {
+	return box(typ, box("data", u32(1), u32(0), []byte(value)))
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestMP4Info(t *testing.T) {
+	created := time.Date(2019, 3, 12, 15, 30, 12, 0, time.UTC)
+	secs := uint32(created.Sub(mp4Epoch) / time.Second)
+
+	// 90.5 s at a time scale of 600
+	mvhd := fullBox("mvhd", 0, u32(secs), u32(secs), u32(600), u32(54300), make([]byte, 80))
+	xyz := []byte("+37.3349-122.0090+010.000/")
+	file := bytes.Join([][]byte{
+		box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		box("free"),
+		box("mdat", make([]byte, 100)),
+		box("moov", mvhd,
+			box("trak", tkhd(0, 0, false)), // audio track
+			box("trak", tkhd(1920, 1080, true)),
+			box("udta",
+				box("\xa9xyz", []byte{0, byte(len(xyz)), 0x15, 0xc7}, xyz),
+				box("\xa9nam", []byte{0, 4, 0, 0}, []byte("Trip")),
+				fullBox("meta", 0, box("hdlr"), box("ilst", item("\xa9ART", "Ann"), item("\xa9day", "2019-03-12"))))),
+	}, nil)
+	info := mp4Info(bytes.NewReader(file))
+	assert.True(t, created.Equal(info.Created), "got %v", info.Created)
+	assert.Equal(t, 90500*time.Millisecond, info.Duration)
+	assert.Equal(t, 1080, info.Width)
+	assert.Equal(t, 1920, info.Height)
+	assert.Equal(t, &Location{Latitude: 37.3349, Longitude: -122.009, Altitude: altitude(10)}, info.Location)
+	assert.Equal(t, MediaTags{Title: "Trip", Artist: "Ann", Year: 2019}, info.Tags)
+	assert.Equal(t, info, readMediaInfo(bytes.NewReader(file)))
+
+	// the sample tables of long videos aren't read into memory
+	long := &countingReader{ReadSeeker: bytes.NewReader(box("moov", mvhd,
+		box("trak", tkhd(1920, 1080, false), box("mdia", make([]byte, 4*maxBoxSize))),
+		box("udta", box("\xa9nam", []byte{0, 4, 0, 0}, []byte("Long")))))}
+	info = mp4Info(long)
+	assert.Equal(t, 1920, info.Width)
+	assert.Equal(t, "Long", info.Tags.Title)
+	assert.Less(t, long.n, int64(1024))
+
+	// version 1 headers have 64 bit times
+	mvhd = fullBox("mvhd", 1, binary.BigEndian.AppendUint64(nil, uint64(secs)), make([]byte, 8), u32(1000),
+		binary.BigEndian.AppendUint64(nil, 1500), make([]byte, 80))
+	info = mp4Info(bytes.NewReader(box("moov", mvhd)))
+	assert.True(t, created.Equal(info.Created))
+	assert.Equal(t, 1500*time.Millisecond, info.Duration)
+
+	// QuickTime metadata keys of Apple devices
+	keys := fullBox("keys", 0, u32(3),
+		box("mdta", []byte("com.apple.quicktime.location.ISO6709")),
+		box("mdta", []byte("com.apple.quicktime.creationdate")),
+		box("mdta", []byte("com.apple.quicktime.content.identifier")))
+	meta := box("meta", box("hdlr"), keys, box("ilst",
+		item("\x00\x00\x00\x01", "+48.8584+002.2945/"),
+		item("\x00\x00\x00\x02", "2019-03-12T16:30:12+0100"),
+		item("\x00\x00\x00\x03", "5A3E1F0C-8D2B-4F6A-9C1E-0B7D2E4F6A81")))
+	info = mp4Info(bytes.NewReader(box("moov", fullBox("mvhd", 0, make([]byte, 96)), meta)))
+	assert.True(t, created.Equal(info.Created), "got %v", info.Created)
+	assert.Equal(t, &Location{Latitude: 48.8584, Longitude: 2.2945}, info.Location)
+	assert.Equal(t, "5A3E1F0C-8D2B-4F6A-9C1E-0B7D2E4F6A81", info.ContentID)
+
+	// zero creation time and files without a movie header
+	info = mp4Info(bytes.NewReader(box("moov", box("mvhd", make([]byte, 100)))))
+	assert.True(t, info.Created.IsZero())
+	assert.Equal(t, MediaInfo{}, mp4Info(bytes.NewReader(box("ftyp", []byte("isom")))))
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+const browseKeys = "↑↓ move  ⏎/→ open  ← up  s/n/m/c sort  space mark  d delete  q quit"
+
+type sortKey int
+
+const (
+	sortBySize sortKey = iota
+	sortByName
+	sortByMtime
+	sortByCount
+)
+
+func (k sortKey) String() string {
+	return [...]string{"size", "name", "mtime", "count"}[k]
+}
+
+// entry is a dir or file listed by the browser
+type entry struct {
+	name   string
+	path   string
+	dir    *fastdu.DirNode // nil for files
+	parent *fastdu.DirNode
+	size   int64
+	items  int64
+	mtime  time.Time
+}
+
+// browser lets the user navigate the dir tree of a scan similar to ncdu;
+// sizes and files are those counted by the scan
+type browser struct {
+	cwd     *fastdu.DirNode
+	sortBy  sortKey
+	reverse bool
+	marked  map[string]entry // path -> marked entry
+	listed  []entry          // entries of cwd in display order
+	cursor  int              // index of the selected entry
+	offset  int              // index of the first entry on screen
+	status  string           // message shown in the last line until the next key
+	in      *bufio.Reader
+	out     io.Writer
+	size    func() (rows, cols int)
+}
+
+// browse scans the specified roots and starts the browser on the result
+func browse(args []string) {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	fs.IntVar(numOpenFiles, "c", *numOpenFiles, "concurrency factor")
+	fs.StringVar(excludePath, "e", *excludePath, "exclude files/dirs in path using specified regex pattern")
+	fs.Var(&symlinks, "l", "symlink policy: count, skip or follow")
+	fs.BoolVar(oneFS, "x", *oneFS, "one file system: don't descend into mount points or dirs on other devices")
+	fs.BoolVar(diskUsage, "u", *diskUsage, "report disk usage (allocated blocks) like du instead of apparent size")
+	fs.DurationVar(printInterval, "f", *printInterval, "print scan progress at frequency specified; disabled with value 0")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s browse [flags] dir...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	scanner, err := fastdu.NewScanner(fastdu.ScanOptions{
+		Roots:            roots,
+		Concurrency:      *numOpenFiles,
+		Exclude:          *excludePath,
+		Symlinks:         symlinks,
+		OneFileSystem:    *oneFS,
+		SizeMode:         sizeMode(),
+		Files:            true,
+		Progress:         fastdu.ProgressFunc(printProgress),
+		ProgressInterval: *printInterval,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	res, err := scanner.Scan(ctx)
+	stop()
+	if err != nil {
+		fmt.Printf("\n**Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	term, err := openTerminal()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer term.close()
+
+	b := &browser{
+		cwd:    res.DirCount.Tree(roots...),
+		marked: make(map[string]entry),
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+		size:   term.size,
+	}
+	b.run()
+}
+
+func (b *browser) run() {
+	b.list()
+	for {
+		b.render()
+		key, err := b.readKey()
+		if err != nil || !b.handle(key) {
+			return
+		}
+	}
+}
+
+// handle performs the action of a key; it returns false if the key quits
+// the browser
+func (b *browser) handle(key string) bool {
+	b.status = ""
+	switch key {
+	case "q", "\x03": // ctrl-c is a key in raw mode
+		return false
+	case "?":
+		b.status = browseKeys
+	case "up", "k":
+		b.move(b.cursor - 1)
+	case "down", "j":
+		b.move(b.cursor + 1)
+	case "pgup":
+		b.move(b.cursor - b.height())
+	case "pgdn":
+		b.move(b.cursor + b.height())
+	case "home", "g":
+		b.move(0)
+	case "end", "G":
+		b.move(len(b.listed) - 1)
+	case "enter", "right", "l":
+		if e, ok := b.selected(); ok && e.dir != nil {
+			b.cwd = e.dir
+			b.list()
+			b.move(0)
+		}
+	case "left", "h", "u", "backspace":
+		if b.cwd.Parent != nil {
+			from := b.cwd
+			b.cwd = b.cwd.Parent
+			b.list()
+			for i, e := range b.listed {
+				if e.dir == from {
+					b.move(i)
+				}
+			}
+		}
+	case "s", "n", "m", "c":
+		key := map[string]sortKey{"s": sortBySize, "n": sortByName, "m": sortByMtime, "c": sortByCount}[key]
+		b.reverse = key == b.sortBy && !b.reverse
+		b.sortBy = key
+		b.list()
+	case " ":
+		if e, ok := b.selected(); ok {
+			b.toggleMark(e)
+			b.move(b.cursor + 1)
+		}
+	case "d":
+		b.deleteMarked()
+	}
+	return true
+}
+
+// readKey reads a key press; escape sequences of the arrow and page keys
+// are returned by name
+func (b *browser) readKey() (string, error) {
+	r, _, err := b.in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case '\r', '\n':
+		return "enter", nil
+	case 127, '\b':
+		return "backspace", nil
+	case 0x1b:
+		// a lone escape has nothing buffered after it
+		if b.in.Buffered() == 0 {
+			return "esc", nil
+		}
+		seq := make([]byte, 0, 4)
+		for b.in.Buffered() > 0 && len(seq) < cap(seq) {
+			c, _ := b.in.ReadByte()
+			seq = append(seq, c)
+			if len(seq) > 1 && (c >= 'A' && c <= 'Z' || c == '~') {
+				break
+			}
+		}
+		switch strings.TrimLeft(string(seq), "[O") {
+		case "A":
+			return "up", nil
+		case "B":
+			return "down", nil
+		case "C":
+			return "right", nil
+		case "D":
+			return "left", nil
+		case "H", "1~":
+			return "home", nil
+		case "F", "4~":
+			return "end", nil
+		case "5~":
+			return "pgup", nil
+		case "6~":
+			return "pgdn", nil
+		}
+		return "esc", nil
+	}
+	return string(r), nil
+}
+
+// height returns the number of entries that fit on the screen below the
+// header and above the status line
+func (b *browser) height() int {
+	rows, _ := b.size()
+	return max(rows-3, 1)
+}
+
+// move selects entry i, clamped to the listed entries, and scrolls it into
+// view
+func (b *browser) move(i int) {
+	b.cursor = max(min(i, len(b.listed)-1), 0)
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if h := b.height(); b.cursor >= b.offset+h {
+		b.offset = b.cursor - h + 1
+	}
+}
+
+func (b *browser) selected() (entry, bool) {
+	if b.cursor >= len(b.listed) {
+		return entry{}, false
+	}
+	return b.listed[b.cursor], true
+}
+
+func (b *browser) toggleMark(e entry) {
+	if _, ok := b.marked[e.path]; ok {
+		delete(b.marked, e.path)
+		return
+	}
+	b.marked[e.path] = e
+}
+
+// list lists the sub dirs and files of the current dir as counted by the
+// scan, in display order
+func (b *browser) list() {
+	var res []entry
+	for _, c := range b.cwd.Children {
+		e := entry{name: c.Name + string(filepath.Separator), path: c.Path, dir: c, parent: b.cwd,
+			size: c.Size, items: c.Items}
+		if fInfo, err := os.Lstat(c.Path); err == nil {
+			e.mtime = fInfo.ModTime()
+		}
+		res = append(res, e)
+	}
+	for _, f := range b.cwd.Files {
+		res = append(res, entry{name: f.Name, path: f.Path, parent: b.cwd, size: f.Size, mtime: f.Modtime})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, c := res[i], res[j]
+		if b.reverse {
+			a, c = c, a
+		}
+		switch b.sortBy {
+		case sortByName:
+			return a.name < c.name
+		case sortByMtime:
+			return a.mtime.After(c.mtime)
+		case sortByCount:
+			return a.items > c.items
+		}
+		return a.size > c.size
+	})
+	b.listed = res
+	b.move(b.cursor)
+}
+
+// render draws the header, the visible entries and the status line
+func (b *browser) render() {
+	rows, cols := b.size()
+	var buf bytes.Buffer
+	line := func(s string, highlight bool) {
+		s = truncate(printable(s), cols)
+		if highlight {
+			s = "\x1b[7m" + s + strings.Repeat(" ", cols-utf8.RuneCountInString(s)) + "\x1b[0m"
+		}
+		buf.WriteString(s + "\x1b[K\r\n")
+	}
+	buf.WriteString("\x1b[H")
+
+	title := b.cwd.Path
+	if title == "" {
+		title = "(all roots)"
+	}
+	header := fmt.Sprintf(" %s  %s  %d items  sort: %s", title, fastdu.FormatSize(b.cwd.Size), b.cwd.Items, b.sortBy)
+	if b.reverse {
+		header += " (reversed)"
+	}
+	if len(b.marked) > 0 {
+		header += fmt.Sprintf("  marked: %d", len(b.marked))
+	}
+	line(header, true)
+	line(strings.Repeat("─", cols), false)
+
+	h := b.height()
+	for i := b.offset; i < b.offset+h; i++ {
+		if i >= len(b.listed) {
+			line("", false)
+			continue
+		}
+		e := b.listed[i]
+		var pct float64
+		if b.cwd.Size > 0 {
+			pct = 100 * float64(e.size) / float64(b.cwd.Size)
+		}
+		bar := strings.Repeat("#", int(pct/10))
+		mark := " "
+		if _, ok := b.marked[e.path]; ok {
+			mark = "*"
+		}
+		items := ""
+		if e.dir != nil {
+			items = fmt.Sprintf("%d items", e.items)
+		}
+		line(fmt.Sprintf("%s %5.1f%% [%-10s] %8s %12s  %s  %s", mark, pct, bar,
+			fastdu.FormatSize(e.size), items, e.mtime.Format("2006-01-02 15:04"), e.name), i == b.cursor)
+	}
+
+	status := b.status
+	if status == "" {
+		status = "? for keys"
+	}
+	buf.WriteString(truncate(printable(status), cols) + "\x1b[K")
+	if rows > h+3 {
+		buf.WriteString("\x1b[J")
+	}
+	b.out.Write(buf.Bytes())
+}
+
+// printable replaces the control characters of s, which file names may
+// contain, so that they can't move the cursor or change the terminal
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// truncate cuts s to n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:max(n, 0)])
+}
+
+// deleteMarked removes the marked entries, or the selected entry if none
+// is marked, from disk after confirmation and subtracts their scanned sizes
+// from the totals of the tree
+func (b *browser) deleteMarked() {
+	marked := b.marked
+	if len(marked) == 0 {
+		e, ok := b.selected()
+		if !ok {
+			return
+		}
+		marked = map[string]entry{e.path: e}
+	}
+	var size int64
+	paths := make([]string, 0, len(marked))
+	for path, e := range marked {
+		paths = append(paths, path)
+		size += e.size
+	}
+	sort.Strings(paths)
+
+	what := paths[0]
+	if len(paths) > 1 {
+		what = fmt.Sprintf("%d entries", len(paths))
+	}
+	b.status = fmt.Sprintf("delete %s (%s)? [y/N]", what, fastdu.FormatSize(size))
+	b.render()
+	if key, err := b.readKey(); err != nil || (key != "y" && key != "Y") {
+		b.status = "cancelled"
+		return
+	}
+
+	var deleted int
+	var freed int64
+	var errs []string
+	for _, path := range paths {
+		e := marked[path]
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if e.dir != nil {
+			e.dir.Detach()
+		} else {
+			e.parent.RemoveFile(path)
+		}
+		delete(b.marked, path)
+		deleted++
+		freed += e.size
+	}
+	b.status = fmt.Sprintf("deleted %d entries, %s", deleted, fastdu.FormatSize(freed))
+	if len(errs) > 0 {
+		b.status += "; " + strings.Join(errs, "; ")
+	}
+	b.list()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajoyka/fdu/fastdu"
+	"github.com/stretchr/testify/assert"
+)
+
+// newBrowser scans dir, which has the files of content by relative path, and
+// browses it with keys as input
+func newBrowser(t *testing.T, dir string, content map[string]string, keys string) (*browser, *bytes.Buffer) {
+	t.Helper()
+	for name, data := range content {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := fastdu.NewScanner(fastdu.ScanOptions{Roots: []string{dir}, Files: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	b := &browser{
+		cwd:    res.DirCount.Tree(dir),
+		marked: make(map[string]entry),
+		in:     bufio.NewReader(strings.NewReader(keys)),
+		out:    &out,
+		size:   func() (int, int) { return 10, 80 },
+	}
+	b.list()
+	return b, &out
+}
+
+func names(b *browser) []string {
+	var res []string
+	for _, e := range b.listed {
+		res = append(res, e.name)
+	}
+	return res
+}
+
+func TestBrowserReadKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"\x1b[A", "up"},
+		{"\x1b[B", "down"},
+		{"\x1bOC", "right"},
+		{"\x1b[D", "left"},
+		{"\x1b[H", "home"},
+		{"\x1b[4~", "end"},
+		{"\x1b[5~", "pgup"},
+		{"\x1b[6~", "pgdn"},
+		{"\x1b", "esc"},
+		{"\r", "enter"},
+		{"\x7f", "backspace"},
+		{"é", "é"},
+	}
+	for _, tt := range tests {
+		b := &browser{in: bufio.NewReader(strings.NewReader(tt.in))}
+		key, err := b.readKey()
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, key, "%q", tt.in)
+	}
+}
+
+func TestBrowserHandle(t *testing.T) {
+	dir := t.TempDir()
+	b, _ := newBrowser(t, dir, map[string]string{
+		"album/a.jpg": strings.Repeat("a", 300),
+		"b.txt":       strings.Repeat("b", 20),
+		"c.txt":       strings.Repeat("c", 10),
+	}, "")
+	assert.Equal(t, []string{"album/", "b.txt", "c.txt"}, names(b))
+
+	assert.True(t, b.handle("end"))
+	assert.Equal(t, 2, b.cursor)
+	assert.True(t, b.handle("down"))
+	assert.Equal(t, 2, b.cursor)
+	assert.True(t, b.handle("home"))
+	assert.True(t, b.handle("enter"))
+	assert.Equal(t, filepath.Join(dir, "album"), b.cwd.Path)
+	assert.Equal(t, []string{"a.jpg"}, names(b))
+	// files aren't opened
+	assert.True(t, b.handle("enter"))
+	assert.Equal(t, filepath.Join(dir, "album"), b.cwd.Path)
+
+	// going up selects the dir that was open
+	assert.True(t, b.handle("left"))
+	assert.Equal(t, dir, b.cwd.Path)
+	assert.Equal(t, 0, b.cursor)
+
+	assert.True(t, b.handle("n"))
+	assert.Equal(t, []string{"album/", "b.txt", "c.txt"}, names(b))
+	assert.True(t, b.handle("n"))
+	assert.True(t, b.reverse)
+	assert.Equal(t, []string{"c.txt", "b.txt", "album/"}, names(b))
+
+	assert.True(t, b.handle(" "))
+	assert.Contains(t, b.marked, filepath.Join(dir, "c.txt"))
+	assert.Equal(t, 1, b.cursor)
+
+	assert.False(t, b.handle("q"))
+	assert.False(t, b.handle("\x03"))
+}
+
+func TestBrowserDelete(t *testing.T) {
+	dir := t.TempDir()
+	// the first d is cancelled by the key after it, the second confirmed
+	b, _ := newBrowser(t, dir, map[string]string{
+		"album/a.jpg": strings.Repeat("a", 300),
+		"b.txt":       strings.Repeat("b", 20),
+		"c.txt":       strings.Repeat("c", 10),
+	}, "ny")
+	size := b.cwd.Size
+
+	b.move(1)
+	b.handle(" ")
+	b.handle(" ")
+	assert.Len(t, b.marked, 2)
+
+	b.handle("d")
+	assert.Equal(t, "cancelled", b.status)
+	assert.FileExists(t, filepath.Join(dir, "b.txt"))
+	assert.Len(t, b.marked, 2)
+
+	b.handle("d")
+	assert.Contains(t, b.status, "deleted 2 entries")
+	assert.NoFileExists(t, filepath.Join(dir, "b.txt"))
+	assert.NoFileExists(t, filepath.Join(dir, "c.txt"))
+	assert.Empty(t, b.marked)
+	assert.Equal(t, []string{"album/"}, names(b))
+	assert.Equal(t, size-30, b.cwd.Size)
+
+	// without marks the selected entry is deleted; the input has ended
+	b.handle("d")
+	assert.Equal(t, "cancelled", b.status)
+	assert.DirExists(t, filepath.Join(dir, "album"))
+}
+
+func TestBrowserRenderEscapes(t *testing.T) {
+	dir := t.TempDir()
+	b, out := newBrowser(t, dir, map[string]string{"a\x1b[2Jb\r.txt": "a"}, "")
+	b.render()
+	assert.Contains(t, out.String(), "a?[2Jb?.txt")
+	assert.NotContains(t, out.String(), "\x1b[2J")
+}
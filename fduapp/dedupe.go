@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	fdb "github.com/ajoyka/fdu/db"
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+const _dedupePlanFile = "dedupe-plan.json"
+
+// dedupe removes files with identical content in three steps: plan writes
+// the files to keep and remove to a plan file for review, run executes a
+// plan and writes an undo log, and undo reverts a run
+func dedupe(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "usage: %s dedupe plan|run|undo [flags]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "  plan  write the files to keep and remove to a plan file for review")
+		fmt.Fprintln(os.Stderr, "  run   execute a plan file and write an undo log")
+		fmt.Fprintln(os.Stderr, "  undo  revert the actions of an undo log")
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "plan":
+		dedupePlan(args[1:])
+	case "run":
+		dedupeRun(args[1:])
+	case "undo":
+		dedupeUndo(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func dedupePlan(args []string) {
+	var opts fastdu.DedupeOptions
+	fs := flag.NewFlagSet("dedupe plan", flag.ExitOnError)
+	fs.StringVar(dbPath, "d", *dbPath, "media database file, or sqlite data source name starting with file:")
+	fs.Var(&opts.Keep, "k", "file to keep: originals (path containing Originals), oldest, shortest (path) or root (file below -r)")
+	fs.StringVar(&opts.Root, "r", "", "dir whose files are kept with -k root")
+	fs.Var(&opts.Action, "a", "action on the other files: delete, quarantine (move below -q), hardlink or reflink")
+	fs.StringVar(&opts.Quarantine, "q", "", "quarantine dir for -a quarantine")
+	output := fs.String("o", _dedupePlanFile, "plan file")
+	fs.Parse(args)
+
+	for _, dir := range []*string{&opts.Root, &opts.Quarantine} {
+		if *dir != "" {
+			*dir, _ = filepath.Abs(*dir)
+		}
+	}
+
+	db, err := fdb.New(fdb.PathOrDSN(*dbPath))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	groups, err := db.DuplicateGroups()
+	if err == nil {
+		opts.Companions, err = db.Companions()
+	}
+	db.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	plan, err := fastdu.PlanDedupe(groups, opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*output, b, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	files, size := plan.Savings()
+	fmt.Printf("%d groups: %s %d files, %s\n", len(plan.Groups), plan.Action, files, fastdu.FormatSize(size))
+	if len(plan.Skipped) > 0 {
+		fmt.Printf("%d groups skipped without a file to keep\n", len(plan.Skipped))
+	}
+	fmt.Printf("review %s, then run: %s dedupe run %s\n", *output, os.Args[0], *output)
+}
+
+func dedupeRun(args []string) {
+	fs := flag.NewFlagSet("dedupe run", flag.ExitOnError)
+	undoFile := fs.String("u", "", "undo log; default dedupe-undo-<time>.jsonl")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s dedupe run [flags] [plan]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	planFile := _dedupePlanFile
+	if fs.NArg() > 0 {
+		planFile = fs.Arg(0)
+	}
+	b, err := os.ReadFile(planFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var plan fastdu.DedupePlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		fmt.Printf("%s: %v\n", planFile, err)
+		os.Exit(1)
+	}
+
+	if *undoFile == "" {
+		*undoFile = fmt.Sprintf("dedupe-undo-%s.jsonl", time.Now().Format("20060102-150405"))
+	}
+	// append so that a log is never lost by running again with the same name
+	undoLog, err := os.OpenFile(*undoFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	res := plan.Execute(undoLog)
+	if err := undoLog.Close(); err != nil {
+		fmt.Println(err)
+	}
+
+	for _, err := range res.Errors {
+		fmt.Println(err)
+	}
+	fmt.Printf("%s: %d files, %s; skipped %d files changed since the plan or already linked; %d errors\n",
+		plan.Action, res.Done, fastdu.FormatSize(res.Bytes), res.Skipped, len(res.Errors))
+	fmt.Printf("undo log: %s\n", *undoFile)
+	if len(res.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func dedupeUndo(args []string) {
+	fs := flag.NewFlagSet("dedupe undo", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s dedupe undo undo-log\n", os.Args[0])
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	done, errs := fastdu.UndoDedupe(f)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	fmt.Printf("restored %d files; %d errors\n", done, len(errs))
+	if len(errs) > 0 {
+		f.Close()
+		os.Exit(1)
+	}
+}
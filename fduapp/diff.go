@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	fdb "github.com/ajoyka/fdu/db"
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+// diff compares two scans given as scan-files.json or file-info.json files
+// or scan ids in media.db; the backup of the previous scan is compared with
+// the latest scan when no scans are specified. file-info.json only has the
+// media files.
+func diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.IntVar(topFiles, "t", *topFiles, "number of files/directories to list per change")
+	fs.StringVar(dbPath, "d", *dbPath, "media database file, or sqlite data source name starting with file:")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s diff [flags] [old new]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "old and new are %s or %s files or scan ids in the media database; default: %s.bak %s\n",
+			_outputFiles, _outputFile, _outputFiles, _outputFiles)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	scans := fs.Args()
+	switch len(scans) {
+	case 0:
+		scans = []string{_outputFiles + ".bak", _outputFiles}
+	case 2:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var states [2][]fastdu.FileState
+	for i, scan := range scans {
+		var err error
+		if states[i], err = loadScan(scan); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("comparing %s (%d files) with %s (%d files)\n", scans[0], len(states[0]), scans[1], len(states[1]))
+	printDiff(fastdu.DiffScans(states[0], states[1]))
+}
+
+// loadScan returns the files of a scan id in media.db, of a scan-files.json
+// file or of a file-info.json file
+func loadScan(scan string) ([]fastdu.FileState, error) {
+	if id, err := strconv.ParseInt(scan, 10, 64); err == nil {
+		db, err := fdb.New(fdb.PathOrDSN(*dbPath))
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		files, err := db.ScanFiles(id)
+		if err == nil && len(files) == 0 {
+			err = fmt.Errorf("scan %d: no files recorded", id)
+		}
+		return files, err
+	}
+
+	b, err := os.ReadFile(scan)
+	if err != nil {
+		return nil, err
+	}
+	// scan-files.json is a list of all files
+	if b = bytes.TrimSpace(b); bytes.HasPrefix(b, []byte("[")) {
+		var files []fastdu.FileState
+		if err := json.Unmarshal(b, &files); err != nil {
+			return nil, fmt.Errorf("%s: %w", scan, err)
+		}
+		return files, nil
+	}
+	// file-info.json has the media files; only the fields needed are decoded.
+	// Older files are keyed by name and hold the path in Dups only
+	var meta map[string]struct {
+		Path    string
+		Size    int64
+		Modtime time.Time
+		Hash    string
+		Dups    []fastdu.Duplicate
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("%s: %w", scan, err)
+	}
+	files := make([]fastdu.FileState, 0, len(meta))
+	for _, m := range meta {
+		path := m.Path
+		if path == "" && len(m.Dups) > 0 {
+			path = m.Dups[0].Name
+		}
+		files = append(files, fastdu.FileState{Path: path, Size: m.Size, Modtime: m.Modtime, Hash: m.Hash, Media: true})
+	}
+	return files, nil
+}
+
+func printDiff(d *fastdu.ScanDiff) {
+	var added, removed, modified int64
+	for _, f := range d.Added {
+		added += f.Size
+	}
+	for _, f := range d.Removed {
+		removed += f.Size
+	}
+	for _, m := range d.Modified {
+		modified += m.New.Size - m.Old.Size
+	}
+	fmt.Printf("added:    %d files, %s\n", len(d.Added), formatDelta(added))
+	fmt.Printf("removed:  %d files, %s\n", len(d.Removed), formatDelta(-removed))
+	fmt.Printf("modified: %d files, %s\n", len(d.Modified), formatDelta(modified))
+	fmt.Printf("moved:    %d files\n", len(d.Moved))
+
+	printList("Added", len(d.Added), func(i int) string {
+		return fmt.Sprintf("%10s  %s", fastdu.FormatSize(d.Added[i].Size), d.Added[i].Path)
+	})
+	printList("Removed", len(d.Removed), func(i int) string {
+		return fmt.Sprintf("%10s  %s", fastdu.FormatSize(d.Removed[i].Size), d.Removed[i].Path)
+	})
+	printList("Modified", len(d.Modified), func(i int) string {
+		m := d.Modified[i]
+		return fmt.Sprintf("%10s  %s", formatDelta(m.New.Size-m.Old.Size), m.New.Path)
+	})
+	printList("Moved/renamed", len(d.Moved), func(i int) string {
+		return fmt.Sprintf("%s -> %s", d.Moved[i].From.Path, d.Moved[i].To.Path)
+	})
+	printList("Directory size changes", len(d.Dirs), func(i int) string {
+		g := d.Dirs[i]
+		return fmt.Sprintf("%10s  %s (%s -> %s)", formatDelta(g.Delta()), g.Dir,
+			fastdu.FormatSize(g.OldSize), fastdu.FormatSize(g.NewSize))
+	})
+}
+
+// printList prints the first -t of n lines under a title
+func printList(title string, n int, line func(i int) string) {
+	if n == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	for i := 0; i < n; i++ {
+		if i == *topFiles {
+			fmt.Printf("  ... %d more\n", n-i)
+			break
+		}
+		fmt.Printf("  %s\n", line(i))
+	}
+}
+
+// formatDelta formats a size difference with its sign
+func formatDelta(n int64) string {
+	switch {
+	case n == 0:
+		return fastdu.FormatSize(0)
+	case n < 0:
+		return "-" + fastdu.FormatSize(-n)
+	}
+	return "+" + fastdu.FormatSize(n)
+}
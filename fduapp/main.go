@@ -1,17 +1,18 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"regexp"
-	"sync"
-	"syscall"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/ajoyka/fdu/db"
+	fdb "github.com/ajoyka/fdu/db"
 	"github.com/ajoyka/fdu/fastdu"
 )
 
@@ -19,183 +20,272 @@ const (
 	_outputDateFile = "date-info.json"
 	_outputFile     = "file-info.json"
 	_outputSizeFile = "size-info.json"
+	_outputScanFile = "scan-info.json"
+	_outputFiles    = "scan-files.json" // all files of the scan, compared by diff
 )
 
-type fileCount struct {
-	mu     sync.Mutex
-	files  int64
-	nbytes int64
+// scanInfo records the totals of a scan; it is used to estimate the time
+// remaining for the next scan of the same roots
+type scanInfo struct {
+	Roots     []string
+	Files     int64
+	Bytes     int64
+	DiskBytes int64
+	Start     time.Time
+	End       time.Time
+	Counters  fastdu.CountersSnapshot
 }
 
 var (
-
-	// fileSizes is used for communicating file size of leaf nodes for incrementing
-	// fileCount
-	fileSizes = make(chan int64)
-
-	wg           sync.WaitGroup
 	topFiles     = flag.Int("t", 10, "number of top files/directories to display")
-	numOpenFiles = flag.Int("c", 20, "concurrency factor")
+	numOpenFiles = flag.Int("c", fastdu.DefaultConcurrency, "concurrency factor")
 	summary      = flag.Bool("s", false, "print summary only")
 	excludePath  = flag.String("e", "", "exclude files/dirs in path using specified regex pattern\n: ex: -e '/a/b|/x/y'")
+	oneFS        = flag.Bool("x", false, "one file system: don't descend into mount points or dirs on other devices")
+	diskUsage    = flag.Bool("u", false, "report disk usage (allocated blocks) like du instead of apparent size")
+	dbPath       = flag.String("d", fdb.DefaultPath, "media database file, or sqlite data source name starting with file:")
+	rescan       = flag.Bool("r", false, "rescan: read every file again instead of reusing data of unchanged files cached in media.db")
+	nearDupDist  = flag.Int("p", -1, "cluster near duplicate images whose perceptual hashes differ by at most the specified number of bits (0-64); disabled when negative")
 
 	printInterval = flag.Duration("f", 5*time.Second, "print summary at frequency specified in seconds; default disabled with value 0")
-	sema          chan struct{}
-	// skip thumb nail files etc.,; use raw strings to avoid backslashes - todo: remove duplicate code in interfaces .go
-	skipFiles      = `/Thumbs/|@eaDir|/rep/ssd/` // add other skip files after specifying '|' for 'OR'ing
-	skipFilesRegex = regexp.MustCompile(skipFiles)
+
+	symlinks fastdu.SymlinkPolicy
 )
 
+func init() {
+	flag.Var(&symlinks, "l", "symlink policy: count (size of the link itself), skip or follow (count targets, descend into linked dirs)")
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "browse":
+			browse(os.Args[2:])
+			return
+		case "diff":
+			diff(os.Args[2:])
+			return
+		case "query":
+			query(os.Args[2:])
+			return
+		case "dedupe":
+			dedupe(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 	createBackup(_outputFile)
+	createBackup(_outputFiles)
 	fastdu.SortedKeys(nil)
-	sema = make(chan struct{}, *numOpenFiles)
-	fmt.Println("concurrency factor", cap(sema), *numOpenFiles)
-	dirCount := fastdu.NewDirCount(*excludePath)
-	fileCount := &fileCount{}
+	fmt.Println("concurrency factor", *numOpenFiles)
 
+	// files are cached by absolute path so that scans from different dirs agree
 	roots := flag.Args()
-
-	var tick <-chan time.Time
-	if *printInterval != 0 {
-		tick = time.Tick(*printInterval)
-	}
-
-	var nbytes, files int64
-
-	fmt.Println()
-	go func() {
-	loop:
-		for {
-			select {
-			case size, ok := <-fileSizes:
-				if !ok {
-					break loop // fileSizes was closed
-				}
-				fileCount.Inc(size)
-
-			case <-tick:
-				files, nbytes = fileCount.Get()
-				fmt.Printf("%d files, %.1fGB\n", files, float64(nbytes)/1e9)
-			}
+	for i, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			roots[i] = abs
 		}
+	}
+	prev := readScanInfo(_outputScanFile, roots)
 
-	}()
+	db, err := fdb.New(fdb.PathOrDSN(*dbPath))
+	if err != nil {
+		fmt.Print(err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	var cache fastdu.Cache = db
+	if *rescan {
+		cache = nil
+	}
 
-	for _, root := range roots {
-		wg.Add(1)
-		go walkDir(root, dirCount, fileSizes)
+	scanner, err := fastdu.NewScanner(fastdu.ScanOptions{
+		Roots:            roots,
+		Concurrency:      *numOpenFiles,
+		Exclude:          *excludePath,
+		Symlinks:         symlinks,
+		OneFileSystem:    *oneFS,
+		PerceptualHash:   *nearDupDist >= 0,
+		SizeMode:         sizeMode(),
+		Cache:            cache,
+		Progress:         fastdu.ProgressFunc(printProgress),
+		ProgressInterval: *printInterval,
+		ExpectedFiles:    prev.Files,
+		ExpectedBytes:    prev.Bytes,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	wg.Wait()
-	close(fileSizes)
-	db, err := db.New()
+	// stop the scan cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Println()
+	res, err := scanner.Scan(ctx)
 	if err != nil {
-		fmt.Print(err)
+		fmt.Printf("\n**Error: %v\n", err)
 		os.Exit(1)
 	}
-	// defer db.Close()
+	dirCount := res.DirCount
 
 	dirCount.PrintFiles(*topFiles, *summary)
-	files, nbytes = fileCount.Get()
-	fmt.Printf("%d files, %.1fGB\n", files, float64(nbytes)/1e9)
+	fmt.Printf("%d files, %.1fGB apparent size, %.1fGB disk usage\n", res.Files,
+		float64(res.Bytes)/1e9, float64(res.DiskBytes)/1e9)
+	printMounts(res.Mounts)
+	dirCount.FindDuplicates()
 	dirCount.WriteMeta(_outputFile)
+	scanID, err := db.WriteScan(fdb.Scan{Roots: roots, Start: res.Start, End: res.End, Flags: setFlags(),
+		Files: res.Files, Bytes: res.Bytes, DiskBytes: res.DiskBytes, Counters: dirCount.CountersSnapshot()})
+	if err != nil {
+		fmt.Println(err)
+	}
 	db.WriteMeta(dirCount.Meta)
+	files := dirCount.FileStates()
+	db.WriteScanFiles(files)
+	writeFiles(_outputFiles, files)
 	db.WriteDuplicates(dirCount.Meta)
+	db.WriteCache(roots, res.Start, dirCount.CacheEntries())
+	db.WriteCompanions(roots, dirCount.CompanionGroups())
+	if *nearDupDist >= 0 {
+		db.WriteNearDuplicates(dirCount.FindNearDuplicates(*nearDupDist))
+	}
+	printDisappeared(db, scanID)
 	dirCount.WriteMetaSortedByDate(_outputDateFile)
 	dirCount.WriteMetaSortedBySize(_outputSizeFile)
 	fmt.Println(dirCount.Counters())
+	writeScanInfo(_outputScanFile, scanInfo{roots, res.Files, res.Bytes, res.DiskBytes, res.Start, res.End,
+		dirCount.CountersSnapshot()})
+}
 
+// printDisappeared lists media files found by the previous scan of the same
+// roots that are gone now
+func printDisappeared(db fdb.DB, scanID int64) {
+	if scanID == 0 {
+		return
+	}
+	gone, err := db.Disappeared(scanID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(gone) == 0 {
+		return
+	}
+	fmt.Printf("%d media files disappeared since the previous scan:\n", len(gone))
+	for i, path := range gone {
+		if i == *topFiles {
+			fmt.Println("  ...")
+			break
+		}
+		fmt.Printf("  %s\n", path)
+	}
 }
 
-// create backup file
-func createBackup(file string) {
-	if _, err := os.Stat(file); err != nil {
+// setFlags returns the flags set on the command line
+func setFlags() string {
+	var flags []string
+	flag.Visit(func(f *flag.Flag) {
+		flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return strings.Join(flags, " ")
+}
+
+// printMounts lists the mount points found during the scan
+func printMounts(mounts []fastdu.MountPoint) {
+	if len(mounts) == 0 {
 		return
 	}
+	fmt.Println("Mount points:")
+	for _, m := range mounts {
+		fsType := m.FSType
+		if fsType == "" {
+			fsType = "unknown"
+		}
+		status := ""
+		if m.Skipped {
+			status = ", skipped"
+		}
+		fmt.Printf("  %s (%s %s%s)\n", m.Path, fsType, m.Source, status)
+	}
+}
 
-	var r []byte
-	var err error
+// sizeMode returns the size reported as selected by the -u flag
+func sizeMode() fastdu.SizeMode {
+	if *diskUsage {
+		return fastdu.DiskUsage
+	}
+	return fastdu.ApparentSize
+}
 
-	if r, err = os.ReadFile(file); err != nil {
-		fmt.Println(err)
+// printProgress prints periodic scan totals
+func printProgress(e fastdu.Event) {
+	if e.Kind != fastdu.EventTick {
 		return
 	}
+	var eta string
+	if e.ETA > 0 {
+		eta = fmt.Sprintf(", ETA %s", e.ETA.Round(time.Second))
+	}
+	fmt.Printf("%d files, %.1fGB, %d images, %d videos%s\n", e.Files, float64(e.Bytes)/1e9,
+		e.Counters.ImageCnt, e.Counters.VideoCnt, eta)
+}
 
-	err = os.WriteFile(file+".bak", r, 0644)
+// readScanInfo returns the totals of the previous scan if it was for the
+// same roots
+func readScanInfo(file string, roots []string) scanInfo {
+	var info scanInfo
+	b, err := os.ReadFile(file)
 	if err != nil {
-		fmt.Println(err)
+		return scanInfo{}
+	}
+	if err := json.Unmarshal(b, &info); err != nil || !slices.Equal(info.Roots, roots) {
+		return scanInfo{}
 	}
+	return info
 }
 
-func walkDir(dir string, dirCount *fastdu.DirCount, fileSizes chan<- int64) {
-
-	// handle case when fastdu is invoked including files as args like so: fastdu *
-	// check if 'dir' is a file
-
-	// fInfo, err := os.Stat(dir)
-	// if err != nil {
-	// 	fmt.Println(err)
-	// }
-	// } else if !fInfo.IsDir() { // 'dir' is a file
-	// 	dirCount.Inc(dir, fInfo.Size())
-	// 	dirCount.AddFile(dir, fInfo)
-	// 	fileSizes <- fInfo.Size()
-	// 	return
-	// }
-
-	defer wg.Done()
-	for _, entry := range dirents(dir) {
-		if entry.IsDir() {
-			subDir := filepath.Join(dir, entry.Name())
-			if skipFilesRegex.MatchString(subDir) {
-				return
-			}
-			wg.Add(1)
-			go walkDir(subDir, dirCount, fileSizes)
-		} else {
-			info, err := entry.Info()
-			if err != nil {
-				fmt.Printf("Error getting fileinfo %s: %v\n", entry.Name(), err)
-				continue
-			}
-			dirCount.Inc(dir, info.Size())
-			dirCount.AddFile(dir, info)
-			fileSizes <- info.Size()
-		}
+func writeScanInfo(file string, info scanInfo) {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		fmt.Println(err)
 	}
 }
 
-func (f *fileCount) Inc(size int64) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	f.files++
-	f.nbytes += size
+// writeFiles writes the files of the scan for diff
+func writeFiles(file string, files []fastdu.FileState) {
+	b, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		fmt.Println(err)
+	}
 }
 
-func (f *fileCount) Get() (int64, int64) {
-	f.mu.Lock()
-	f.mu.Unlock()
+// create backup file
+func createBackup(file string) {
+	if _, err := os.Stat(file); err != nil {
+		return
+	}
 
-	return f.files, f.nbytes
-}
+	var r []byte
+	var err error
 
-func dirents(dir string) []os.DirEntry {
-	sema <- struct{}{} // acquire token
-	defer func() {
-		<-sema // release token
-	}()
+	if r, err = os.ReadFile(file); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	dirEntries, err := os.ReadDir(dir)
+	err = os.WriteFile(file+".bak", r, 0644)
 	if err != nil {
-		if errors.Is(err, syscall.EMFILE) {
-			fmt.Printf("\n**Error: %s\nReduce concurrency and retry\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("%s, %v\n", dir, err)
-		return nil
+		fmt.Println(err)
 	}
-	return dirEntries
 }
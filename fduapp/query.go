@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	fdb "github.com/ajoyka/fdu/db"
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+// query runs a built-in report over the media database
+func query(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.StringVar(dbPath, "d", *dbPath, "media database file, or sqlite data source name starting with file:")
+	fs.IntVar(topFiles, "t", 20, "number of rows of reports listing files")
+	format := fs.String("o", "table", "output format: table, json or csv")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s query [flags] report\n\nreports:\n", os.Args[0])
+		for _, r := range fdb.Reports {
+			fmt.Fprintf(fs.Output(), "  %-14s %s\n", r.Name, r.Description)
+		}
+		fmt.Fprintln(fs.Output(), "\nflags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	report, ok := fdb.FindReport(fs.Arg(0))
+	if !ok {
+		fmt.Printf("unknown report %q\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db, err := fdb.New(fdb.PathOrDSN(*dbPath))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	res, err := db.RunReport(report, *topFiles)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "json":
+		err = writeReportJSON(os.Stdout, res)
+	case "csv":
+		err = writeReportCSV(os.Stdout, res)
+	case "table":
+		err = writeReportTable(os.Stdout, res)
+	default:
+		err = fmt.Errorf("unknown output format %q", *format)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// writeReportJSON writes the rows as a list of objects keyed by column
+func writeReportJSON(w io.Writer, res *fdb.ReportResult) error {
+	rows := make([]map[string]any, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		obj := make(map[string]any, len(row))
+		for i, v := range row {
+			obj[res.Columns[i]] = v
+		}
+		rows = append(rows, obj)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+func writeReportCSV(w io.Writer, res *fdb.ReportResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write(res.Columns)
+	for _, row := range res.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = formatValue(v)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeReportTable writes aligned columns; sizes are shown human readable
+func writeReportTable(w io.Writer, res *fdb.ReportResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, col := range res.Columns {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, col)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range res.Rows {
+		for i, v := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			switch res.Columns[i] {
+			case "size", "bytes", "wasted":
+				if n, ok := v.(int64); ok {
+					fmt.Fprint(tw, fastdu.FormatSize(n))
+					continue
+				}
+			}
+			fmt.Fprint(tw, formatValue(v))
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
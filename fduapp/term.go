@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+)
+
+// terminal is the controlling terminal switched to raw mode and to the
+// alternate screen, so that the browser gets every key press and leaves the
+// screen as it was when it quits
+type terminal struct {
+	saved   string // stty settings restored on close
+	resized chan os.Signal
+
+	mu         sync.Mutex
+	rows, cols int // size read on open and when the terminal is resized
+}
+
+// openTerminal puts the terminal on stdin into raw mode; it fails if stdin
+// isn't a terminal
+func openTerminal() (*terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("browse needs a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("raw mode: %v", err)
+	}
+	// alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+
+	t := &terminal{saved: strings.TrimSpace(saved), resized: make(chan os.Signal, 1)}
+	t.readSize()
+	if len(resizeSignals) > 0 {
+		signal.Notify(t.resized, resizeSignals...)
+	}
+	go func() {
+		for range t.resized {
+			t.readSize()
+		}
+	}()
+	return t, nil
+}
+
+// close restores the screen and the settings of the terminal
+func (t *terminal) close() {
+	signal.Stop(t.resized)
+	close(t.resized)
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	stty(t.saved)
+}
+
+// size returns the number of rows and columns of the terminal
+func (t *terminal) size() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rows, t.cols
+}
+
+// readSize asks the terminal for its size; 24x80 if it is unknown
+func (t *terminal) readSize() {
+	rows, cols := 24, 80
+	if out, err := stty("size"); err == nil {
+		var r, c int
+		if n, _ := fmt.Sscan(out, &r, &c); n == 2 && r > 0 && c > 0 {
+			rows, cols = r, c
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows, t.cols = rows, cols
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// resizeSignals are not supported on this platform; the size is read once
+var resizeSignals []os.Signal
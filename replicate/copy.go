@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+// collisionPolicy decides what happens when a different file already has
+// the destination of a copy; files with identical content are never copied
+// twice
+type collisionPolicy int
+
+const (
+	collisionRename collisionPolicy = iota // copy to name_1.ext, name_2.ext, ...
+	collisionSkip                          // keep the existing file
+	collisionLarger                        // keep the larger of both files
+	collisionFail                          // report an error
+)
+
+var collisionPolicies = [...]string{"rename", "skip", "larger", "fail"}
+
+func (p collisionPolicy) String() string {
+	if p < 0 || int(p) >= len(collisionPolicies) {
+		return "unknown"
+	}
+	return collisionPolicies[p]
+}
+
+// Set parses the policy name; it implements flag.Value
+func (p *collisionPolicy) Set(s string) error {
+	i := slices.Index(collisionPolicies[:], s)
+	if i < 0 {
+		return fmt.Errorf("unknown collision policy %q, expecting one of %v", s, collisionPolicies)
+	}
+	*p = collisionPolicy(i)
+	return nil
+}
+
+// transferMode is how files get into the destination tree
+type transferMode int
+
+const (
+	modeCopy     transferMode = iota // verified copy
+	modeMove                         // rename, or verified copy and delete across devices
+	modeHardlink                     // hard link to the source; same file system only
+	modeSymlink                      // symbolic link to the source
+	modeReflink                      // copy sharing the data blocks of the source (btrfs, xfs)
+)
+
+var transferModes = [...]string{"copy", "move", "hardlink", "symlink", "reflink"}
+
+func (m transferMode) String() string {
+	if m < 0 || int(m) >= len(transferModes) {
+		return "unknown"
+	}
+	return transferModes[m]
+}
+
+// Set parses the mode name; it implements flag.Value
+func (m *transferMode) Set(s string) error {
+	i := slices.Index(transferModes[:], s)
+	if i < 0 {
+		return fmt.Errorf("unknown mode %q, expecting one of %v", s, transferModes)
+	}
+	*m = transferMode(i)
+	return nil
+}
+
+// MarshalText encodes the mode by name in plan files
+func (m transferMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a mode name; plans written before modes existed
+// copy
+func (m *transferMode) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*m = modeCopy
+		return nil
+	}
+	return m.Set(string(b))
+}
+
+// outcome of a copy
+const (
+	statusCopied    = "copied"
+	statusRenamed   = "renamed"   // copied to a numbered name
+	statusReplaced  = "replaced"  // the existing smaller file was replaced
+	statusIdentical = "identical" // the destination has the same content
+	statusKept      = "kept"      // a different existing file was kept
+)
+
+// placeMu serializes the renames of new files so that workers don't claim
+// the same destination
+var placeMu sync.Mutex
+
+// transferFile copies, moves or links srcFile to dstFile or, depending on
+// policy, to a numbered name next to it. The new file is created as a temp
+// file next to the destination and renamed to the destination once it is
+// complete; copies are verified by hashing them again and get the
+// permissions and modification time of the source, so that an interrupted
+// copy never leaves a partial file under its final name. Moved files are
+// removed from the source unless a different existing file was kept or the
+// destination resolves to the source, e.g. a symbolic link to it. It returns
+// the path of the destination and the outcome.
+func transferFile(srcFile, dstFile string, policy collisionPolicy, mode transferMode) (string, string, error) {
+	if filepath.Clean(srcFile) == filepath.Clean(dstFile) {
+		return dstFile, statusIdentical, nil
+	}
+	dst, status, err := place(srcFile, dstFile, policy, mode)
+	if err == nil && mode == modeMove && status != statusKept {
+		var same bool
+		if same, err = resolvesTo(srcFile, dst); err == nil && !same {
+			err = os.Remove(srcFile)
+		}
+	}
+	return dst, status, err
+}
+
+// resolvesTo reports whether dst is src under another name: a symbolic link
+// to it or a path through linked directories. Removing src would then lose
+// its content; hard links of src keep it.
+func resolvesTo(src, dst string) (bool, error) {
+	realSrc, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return false, err
+	}
+	realDst, err := filepath.EvalSymlinks(dst)
+	if err != nil {
+		return false, err
+	}
+	return realSrc == realDst, nil
+}
+
+func place(srcFile, dstFile string, policy collisionPolicy, mode transferMode) (string, string, error) {
+	srcInfo, err := os.Stat(srcFile)
+	if err != nil {
+		return "", "", err
+	}
+	// copies of a previous run aren't written again
+	var hash string
+	if _, err := os.Lstat(dstFile); err == nil {
+		if done, err := placed(srcFile, dstFile, srcInfo, mode, &hash); err != nil {
+			return "", "", err
+		} else if done {
+			return dstFile, statusIdentical, nil
+		}
+	}
+
+	tmp := tempName(srcFile, dstFile)
+	h, err := prepare(srcFile, tmp, srcInfo, mode)
+	if err != nil {
+		return "", "", err
+	}
+	if hash == "" {
+		hash = h
+	}
+	defer os.Remove(tmp) // unless it was renamed
+
+	for n := 0; ; n++ {
+		dst := numbered(dstFile, n)
+		ok, err := rename(tmp, dst)
+		if err != nil {
+			return "", "", err
+		}
+		if ok {
+			if n > 0 {
+				return dst, statusRenamed, nil
+			}
+			return dst, statusCopied, nil
+		}
+
+		// collision: compare the existing file
+		if done, err := placed(srcFile, dst, srcInfo, mode, &hash); err != nil {
+			return "", "", err
+		} else if done {
+			return dst, statusIdentical, nil
+		}
+		switch policy {
+		case collisionRename:
+			continue
+		case collisionSkip:
+			return dst, statusKept, nil
+		case collisionLarger:
+			replaced, err := replace(tmp, dst, srcInfo.Size())
+			if err != nil {
+				return "", "", err
+			}
+			if !replaced {
+				return dst, statusKept, nil
+			}
+			return dst, statusReplaced, nil
+		default:
+			return "", "", fmt.Errorf("%s exists with different content", dst)
+		}
+	}
+}
+
+// placed reports whether the existing dst already is what transferring src
+// in mode would create: a symbolic link to src, a hard link of src or, for
+// the other modes, a regular file with the content of src. Links to src
+// don't count as copies and files other than regular files never have the
+// same content, so they are collisions. hash caches the hash of src.
+func placed(src, dst string, srcInfo os.FileInfo, mode transferMode, hash *string) (bool, error) {
+	dstInfo, err := os.Lstat(dst)
+	if err != nil {
+		return false, err
+	}
+	switch mode {
+	case modeSymlink:
+		if dstInfo.Mode()&fs.ModeSymlink == 0 {
+			return false, nil
+		}
+		target, err := os.Stat(dst)
+		if err != nil {
+			return false, nil // dangling link
+		}
+		return os.SameFile(srcInfo, target), nil
+	case modeHardlink, modeMove:
+		// a move that was interrupted after linking the destination
+		if dstInfo.Mode().IsRegular() && os.SameFile(srcInfo, dstInfo) {
+			return true, nil
+		}
+	}
+	if !dstInfo.Mode().IsRegular() || os.SameFile(srcInfo, dstInfo) || dstInfo.Size() != srcInfo.Size() {
+		return false, nil
+	}
+	if *hash == "" {
+		if *hash, err = fastdu.HashFile(src); err != nil {
+			return false, err
+		}
+	}
+	dstHash, err := fastdu.HashFile(dst)
+	if err != nil {
+		return false, err
+	}
+	return dstHash == *hash, nil
+}
+
+// prepare creates tmp as the new file for src; it returns the hash of the
+// content if it was computed
+func prepare(src, tmp string, srcInfo os.FileInfo, mode transferMode) (string, error) {
+	os.Remove(tmp)
+	switch mode {
+	case modeHardlink:
+		return "", sameFileSystem(os.Link(src, tmp), mode)
+	case modeSymlink:
+		return "", os.Symlink(src, tmp)
+	case modeReflink:
+		if err := fastdu.Reflink(src, tmp); err != nil {
+			return "", sameFileSystem(err, mode)
+		}
+		hash, err := fastdu.HashFile(src)
+		if err == nil {
+			err = finish(tmp, hash, srcInfo)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		return hash, nil
+	case modeMove:
+		// on the same file system a hard link is renamed into place before the
+		// source is removed; other devices and file systems without hard links
+		// get a verified copy
+		if err := os.Link(src, tmp); err == nil {
+			return "", nil
+		}
+	}
+	return copyTemp(src, tmp, srcInfo)
+}
+
+// sameFileSystem replaces the error of linking across file systems by one
+// that tells what the mode needs
+func sameFileSystem(err error, mode transferMode) error {
+	if errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("%s mode needs source and destination on the same file system", mode)
+	}
+	return err
+}
+
+// numbered returns file for n == 0 and file with _n before the extension
+// otherwise
+func numbered(file string, n int) string {
+	if n == 0 {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(file, ext), n, ext)
+}
+
+// tempName returns the hidden temp file that src is copied to before it is
+// renamed to dst; the name is the same for every run so that the leftover
+// of an interrupted copy is replaced by the next run
+func tempName(src, dst string) string {
+	sum := sha256.Sum256([]byte(src))
+	return filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.%x.replicate-tmp", filepath.Base(dst), sum[:4]))
+}
+
+// copyTemp copies src to tmp and returns the hash of the content; tmp is
+// removed if the copy fails
+func copyTemp(src, tmp string, srcInfo os.FileInfo) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	os.Remove(tmp)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, srcInfo.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if err == nil {
+		err = finish(tmp, hash, srcInfo)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return hash, nil
+}
+
+// rename renames tmp to dst unless dst exists
+func rename(tmp, dst string) (bool, error) {
+	placeMu.Lock()
+	defer placeMu.Unlock()
+	if _, err := os.Lstat(dst); err == nil {
+		return false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, os.Rename(tmp, dst)
+}
+
+// replace renames tmp over dst unless dst has at least size bytes; the size
+// is compared under the lock so that of two workers replacing the same file
+// the larger copy wins
+func replace(tmp, dst string, size int64) (bool, error) {
+	placeMu.Lock()
+	defer placeMu.Unlock()
+	dstInfo, err := os.Lstat(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if err == nil && dstInfo.Size() >= size {
+		return false, nil
+	}
+	return true, os.Rename(tmp, dst)
+}
+
+// finish verifies that the content of the copy dst has the hash computed
+// while copying, and sets the permissions and modification time of the
+// source
+func finish(dst, hash string, srcInfo os.FileInfo) error {
+	got, err := fastdu.HashFile(dst)
+	if err != nil {
+		return err
+	}
+	if got != hash {
+		return fmt.Errorf("%s: verification failed, content differs from the source", dst)
+	}
+	// the permissions of new files are masked by the umask
+	if err := os.Chmod(dst, srcInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ajoyka/fdu/fastdu"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile creates file with content, and its directory
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestMoveOverLinks moves sources into a tree that already links to them:
+// the content must survive in a file of its own
+func TestMoveOverLinks(t *testing.T) {
+	for _, first := range []transferMode{modeSymlink, modeHardlink} {
+		t.Run(first.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src", "a.jpg"), filepath.Join(dir, "dst", "a.jpg")
+			writeFile(t, src, "photo")
+			assert.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+
+			_, status, err := transferFile(src, dst, collisionRename, first)
+			assert.NoError(t, err)
+			assert.Equal(t, statusCopied, status)
+
+			// the link is identical for the same mode
+			_, status, err = transferFile(src, dst, collisionRename, first)
+			assert.NoError(t, err)
+			assert.Equal(t, statusIdentical, status)
+
+			final, _, err := transferFile(src, dst, collisionRename, modeMove)
+			assert.NoError(t, err)
+			info, err := os.Lstat(final)
+			if assert.NoError(t, err) {
+				assert.True(t, info.Mode().IsRegular())
+			}
+			assert.Equal(t, "photo", readFile(t, final))
+		})
+	}
+}
+
+// TestMoveToItself moves a source to a destination directory that links to
+// the source directory
+func TestMoveToItself(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "a.jpg")
+	writeFile(t, src, "photo")
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "src"), filepath.Join(dir, "dst")))
+
+	_, status, err := transferFile(src, filepath.Join(dir, "dst", "a.jpg"), collisionRename, modeMove)
+	assert.NoError(t, err)
+	assert.Equal(t, statusIdentical, status)
+	assert.Equal(t, "photo", readFile(t, src))
+}
+
+// TestCopyOverLinks copies sources into a tree that links to them: a link
+// isn't a backup, so the copy gets a name of its own
+func TestCopyOverLinks(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src", "a.jpg"), filepath.Join(dir, "dst", "a.jpg")
+	writeFile(t, src, "photo")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+	assert.NoError(t, os.Symlink(src, dst))
+
+	final, status, err := transferFile(src, dst, collisionRename, modeCopy)
+	assert.NoError(t, err)
+	assert.Equal(t, statusRenamed, status)
+	assert.Equal(t, filepath.Join(dir, "dst", "a_1.jpg"), final)
+	info, err := os.Lstat(final)
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode().IsRegular())
+	}
+}
+
+func TestTransferFilePolicies(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     collisionPolicy
+		existing   string // content of the destination before the copy, none if empty
+		wantStatus string
+		wantFinal  string
+		wantDst    string // content of the destination after the copy
+		wantErr    bool
+	}{
+		{name: "new", policy: collisionFail, wantStatus: statusCopied, wantFinal: "a.jpg", wantDst: "photo"},
+		{name: "identical", policy: collisionFail, existing: "photo", wantStatus: statusIdentical, wantFinal: "a.jpg", wantDst: "photo"},
+		{name: "rename", policy: collisionRename, existing: "other", wantStatus: statusRenamed, wantFinal: "a_1.jpg", wantDst: "other"},
+		{name: "skip", policy: collisionSkip, existing: "other", wantStatus: statusKept, wantFinal: "a.jpg", wantDst: "other"},
+		{name: "larger replaces smaller", policy: collisionLarger, existing: "old", wantStatus: statusReplaced, wantFinal: "a.jpg", wantDst: "photo"},
+		{name: "larger keeps larger", policy: collisionLarger, existing: "larger photo", wantStatus: statusKept, wantFinal: "a.jpg", wantDst: "larger photo"},
+		{name: "fail", policy: collisionFail, existing: "other", wantErr: true, wantDst: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src", "a.jpg"), filepath.Join(dir, "dst", "a.jpg")
+			writeFile(t, src, "photo")
+			if tt.existing != "" {
+				writeFile(t, dst, tt.existing)
+			} else {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+			}
+
+			final, status, err := transferFile(src, dst, tt.policy, modeCopy)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantStatus, status)
+				assert.Equal(t, filepath.Join(dir, "dst", tt.wantFinal), final)
+				if status != statusKept {
+					assert.Equal(t, "photo", readFile(t, final))
+				}
+			}
+			assert.Equal(t, tt.wantDst, readFile(t, dst))
+			assert.Equal(t, "photo", readFile(t, src))
+			// no temp file is left behind
+			entries, _ := os.ReadDir(filepath.Dir(dst))
+			for _, e := range entries {
+				assert.NotContains(t, e.Name(), ".replicate-tmp")
+			}
+		})
+	}
+}
+
+func TestTransferFileModes(t *testing.T) {
+	mtime := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	for _, mode := range []transferMode{modeCopy, modeMove, modeHardlink, modeSymlink, modeReflink} {
+		t.Run(mode.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src", "a.jpg"), filepath.Join(dir, "dst", "a.jpg")
+			writeFile(t, src, "photo")
+			assert.NoError(t, os.Chmod(src, 0o640))
+			assert.NoError(t, os.Chtimes(src, mtime, mtime))
+			srcInfo, err := os.Stat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.NoError(t, os.MkdirAll(filepath.Dir(dst), 0o755))
+
+			final, status, err := transferFile(src, dst, collisionFail, mode)
+			if mode == modeReflink && errors.Is(err, fastdu.ErrReflinkUnsupported) {
+				t.Skip(err)
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, dst, final)
+			assert.Equal(t, statusCopied, status)
+			assert.Equal(t, "photo", readFile(t, dst))
+
+			dstInfo, err := os.Lstat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch mode {
+			case modeHardlink:
+				assert.True(t, os.SameFile(srcInfo, dstInfo))
+			case modeSymlink:
+				assert.Equal(t, os.ModeSymlink, dstInfo.Mode().Type())
+			default:
+				assert.True(t, dstInfo.Mode().IsRegular())
+				assert.Equal(t, os.FileMode(0o640), dstInfo.Mode().Perm())
+				assert.True(t, mtime.Equal(dstInfo.ModTime()))
+			}
+			_, err = os.Stat(src)
+			if mode == modeMove {
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSameFileSystem(t *testing.T) {
+	err := &os.LinkError{Op: "link", Old: "/a/a.jpg", New: "/b/a.jpg", Err: syscall.EXDEV}
+	assert.EqualError(t, sameFileSystem(err, modeHardlink), "hardlink mode needs source and destination on the same file system")
+	assert.NoError(t, sameFileSystem(nil, modeHardlink))
+	reflinkErr := fmt.Errorf("%w across file systems: %w", fastdu.ErrReflinkUnsupported, syscall.EXDEV)
+	assert.EqualError(t, sameFileSystem(reflinkErr, modeReflink), "reflink mode needs source and destination on the same file system")
+	other := &os.LinkError{Op: "link", Old: "/a/a.jpg", New: "/b/a.jpg", Err: syscall.EEXIST}
+	assert.Equal(t, other, sameFileSystem(other, modeHardlink))
+}
+
+// TestTransferFileInterrupted copies over the temp file of a copy that was
+// interrupted
+func TestTransferFileInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src", "a.jpg"), filepath.Join(dir, "dst", "a.jpg")
+	writeFile(t, src, "photo")
+	tmp := tempName(src, dst)
+	writeFile(t, tmp, "pho")
+
+	final, status, err := transferFile(src, dst, collisionFail, modeCopy)
+	assert.NoError(t, err)
+	assert.Equal(t, statusCopied, status)
+	assert.Equal(t, "photo", readFile(t, final))
+	_, err = os.Lstat(tmp)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestFinishVerifies(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.jpg")
+	writeFile(t, file, "photo")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fastdu.HashFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, finish(file, hash, info))
+
+	writeFile(t, file, "fotos")
+	assert.ErrorContains(t, finish(file, hash, info), "verification failed")
+}
+
+func TestReplaceKeepsLarger(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "a.jpg")
+	writeFile(t, dst, "larger photo")
+	tmp := filepath.Join(dir, ".a.jpg.tmp")
+	writeFile(t, tmp, "photo")
+
+	replaced, err := replace(tmp, dst, 5)
+	assert.NoError(t, err)
+	assert.False(t, replaced)
+	assert.Equal(t, "larger photo", readFile(t, dst))
+
+	replaced, err = replace(tmp, dst, 100)
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.Equal(t, "photo", readFile(t, dst))
+}
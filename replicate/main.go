@@ -1,16 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
-	"time"
 
 	// "github.com/google/gops/agent"
 
@@ -21,18 +20,23 @@ import (
 
 // Create date based dirs and copy over files from source dirs
 
-const (
-	mediaDB = "../fduapp/media.db" // todo: make it command line option
-)
-
 var (
 	outDirPrefix = flag.String("p", ".", "Prefix root directory path to create output directory. Default is to use current directory")
+	dbPath       = flag.String("d", "../fduapp/media.db", "media database file, or sqlite data source name starting with file:")
+	dryRun       = flag.Bool("n", false, "dry run: write the plan of copies to the -o file instead of copying")
+	planFile     = flag.String("o", "replicate-plan.json", "plan file written by a dry run; - for stdout")
+	execPlan     = flag.String("e", "", "execute a plan file written by a dry run instead of querying the database")
+	restart      = flag.Bool("r", false, "restart: forget the copies recorded in the journal by previous runs instead of resuming")
+	template     = flag.String("t", fastdu.DefaultTemplate, "layout of the destinations below -p; fields: "+strings.Join(fastdu.TemplateFields, ", "))
+	collisions   collisionPolicy
+	mode         transferMode
+	dateOrder    = append(fastdu.DateOrder(nil), fastdu.DefaultDateOrder...)
 )
 
-type job struct {
-	dir string
-	src string
-	dst string
+func init() {
+	flag.Var(&mode, "m", "how files get into the date tree: copy, move (copy and delete across devices), hardlink, symlink or reflink (btrfs, xfs); a plan executed with -e keeps its mode unless -m is set")
+	flag.Var(&collisions, "c", "when a different file has the destination: rename (to name_1.ext, ...), skip, larger (keep the larger file) or fail; identical files are never copied twice")
+	flag.Var(&dateOrder, "s", "date sources for the date dirs in order of precedence: exif (DateTimeOriginal), create (CreateDate), video (container creation time), filename (e.g. IMG_20190312_...) and mtime")
 }
 
 func main() {
@@ -42,135 +46,98 @@ func main() {
 	// 	log.Fatal(err)
 	// }
 
-	// Check link for avoiding db lock errors: https://github.com/mattn/go-sqlite3?tab=readme-ov-file#faq
 	flag.Parse()
-	fmt.Printf("outp->%s\n", *outDirPrefix)
-	dsn := fmt.Sprintf("file:%s?cache=shared", mediaDB)
-	db, err := sql.Open("sqlite3", dsn)
+	// create or upgrade the tables before the plan queries them
+	media, err := fdb.New(fdb.PathOrDSN(*dbPath))
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// ping database to verify connection
-	if err := db.Ping(); err != nil {
+	media.Close()
+	db, err := fdb.Open(fdb.PathOrDSN(*dbPath))
+	if err != nil {
 		log.Fatal(err)
 	}
+	defer db.Close()
 
-	query := `select %s from media where size > 20000 and mime_type = 'image' or mime_type = 'video' ;`
-	query = fmt.Sprintf(query, fdb.MediaDBCols)
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Fatalf("failed to query %s got error %v", query, err)
+	var plan *Plan
+	if *execPlan != "" {
+		plan, err = readPlan(*execPlan)
+	} else {
+		plan, err = queryPlan(db)
 	}
-
-	jobs := make(chan job)
-	// todo: find largest file path in slice
-	// todo: don't create dir if it exists by keeping them in hashmap
-	go func() {
-		defer close(jobs)
-		createCopyJobs(rows, jobs)
-	}()
-
-	numWorkers := 10
-	var wg sync.WaitGroup
-	wg.Add(numWorkers)
-
-	for i := 0; i < numWorkers; i++ {
-		go func() {
-			defer wg.Done()
-			for job := range jobs {
-				dirPath := job.dir
-				srcPath := job.src
-				dstPath := job.dst
-				fmt.Printf("%s->%s\n", srcPath, dstPath)
-				if err := os.MkdirAll(dirPath, 0755); err != nil {
-					log.Fatal(err)
-				}
-				if err := copyFile(srcPath, dstPath); err != nil {
-					log.Fatal(err)
-				}
-			}
-
-		}()
+	if err != nil {
+		log.Fatal(err)
 	}
-	wg.Wait()
-}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "m" {
+			plan.Mode = mode
+		}
+	})
 
-func createCopyJobs(rows *sql.Rows, jobsOut chan<- job) {
-	for rows.Next() {
-		var name, size, mime_type,
-			mime_subtype, mime_value, extension,
-			suffix_common_path, max_common_path, filepath, exif_json string
-		var datetime time.Time
-		var exif_datetime_original sql.NullTime
-		var count, file_size_mismatch int
-		err := rows.Scan(&name, &size, &datetime, &exif_datetime_original,
-			&mime_type, &mime_subtype, &mime_value, &extension, &count,
-			&file_size_mismatch, &suffix_common_path, &max_common_path, &filepath, &exif_json,
-		)
-		if err != nil {
+	if *dryRun {
+		if err := writePlan(*planFile, plan); err != nil {
 			log.Fatal(err)
 		}
-		var dups []fastdu.Duplicate
-		err = json.Unmarshal([]byte(filepath), &dups)
-		if err != nil {
-			log.Fatalf("name: %s, filepath: %s, %v", name, filepath, err)
+		fmt.Fprintf(os.Stderr, "plan: %s\n", plan.summary())
+		if *planFile != "-" {
+			fmt.Fprintf(os.Stderr, "review %s, then run: %s -e %s\n", *planFile, os.Args[0], *planFile)
 		}
-		// fmt.Printf("%s, %d, %v, %v\n", name, count, datetime, dups)
-
-		year := datetime.Year()
-		month := datetime.Month()
-		day := datetime.Day()
-
-		dirPath := fmt.Sprintf("%s/%d/%02d/%02d", *outDirPrefix, year, month, day)
-		srcPath := getOriginalIfExists(dups)
-		dstPath := dirPath + "/" + name
+		return
+	}
 
-		jobsOut <- job{
-			dir: dirPath,
-			src: srcPath,
-			dst: dstPath,
-		}
-		fmt.Printf("%s->%s\n", srcPath, dstPath)
-		if err := os.MkdirAll(dirPath, 0755); err != nil {
-			log.Fatal(err)
-		}
-		if err := copyFile(srcPath, dstPath); err != nil {
+	// the journal lets a rerun resume an interrupted run
+	journal, err := fdb.OpenJournal(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *restart {
+		if err := journal.Clear(); err != nil {
 			log.Fatal(err)
 		}
-
 	}
-}
 
-func getOriginalIfExists(dups []fastdu.Duplicate) string {
-	for _, dup := range dups {
-		if strings.Contains(dup.Name, "Originals") { // get unedited photo from iphoto library
-			return dup.Name
-		}
+	// on Ctrl-C the copies in progress are finished
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("outp->%s\n", plan.Prefix)
+	res := plan.execute(ctx, 10, collisions, journal)
+	for _, err := range res.errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	companions := 0
+	for _, a := range plan.Actions {
+		companions += len(a.Companions)
+	}
+	fmt.Printf("%s %d files and %d companions: %s\n", plan.Mode, len(plan.Actions), companions, res)
+	if ctx.Err() != nil {
+		fmt.Println("interrupted; run again to resume")
+	}
+	if len(res.errs) > 0 {
+		db.Close()
+		os.Exit(1)
 	}
-	return dups[0].Name
 }
 
-func copyFile(srcFile, dstFile string) error {
-	src, err := os.Open(srcFile)
+// queryPlan builds the plan from the media database
+func queryPlan(db *sql.DB) (*Plan, error) {
+	tmpl, err := fastdu.ParseTemplate(*template)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer src.Close()
-
-	dst, err := os.Create(dstFile)
+	// destinations are absolute so that a saved plan can be executed from
+	// any dir
+	prefix, err := filepath.Abs(*outDirPrefix)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer dst.Close()
+	return buildPlan(db, prefix, dateOrder, tmpl)
+}
 
-	_, err = io.Copy(dst, src)
-	if err != nil {
-		return err
-	}
-	err = dst.Sync()
-	if err != nil {
-		return err
+func getOriginalIfExists(dups []fastdu.Duplicate) string {
+	for _, dup := range dups {
+		if strings.Contains(dup.Name, "Originals") { // get unedited photo from iphoto library
+			return dup.Name
+		}
 	}
-	return nil
+	return dups[0].Name
 }
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fdb "github.com/ajoyka/fdu/db"
+	"github.com/ajoyka/fdu/fastdu"
+)
+
+// conflict status of a destination when the plan was made
+const (
+	conflictNone      = "none"      // destination doesn't exist
+	conflictExists    = "exists"    // destination exists with the same size
+	conflictDiffers   = "differs"   // destination exists with a different size
+	conflictDuplicate = "duplicate" // another file of the plan has the same destination
+)
+
+// Action copies, moves or links a file into the date tree
+type Action struct {
+	Source      string
+	Destination string
+	Reason      string            // why the file lands at the destination
+	Date        time.Time         // capture date that selects the date dir
+	DateSource  fastdu.DateSource // where Date comes from
+	Size        int64
+	Conflict    string
+	Companions  []Companion `json:",omitempty"` // files that follow the source
+}
+
+// Companion is a file that belongs to the source of an action, such as a
+// sidecar or the video of a Live Photo; it follows the source to its final
+// destination
+type Companion struct {
+	Source      string
+	Destination string // planned destination
+	Kind        fastdu.CompanionKind
+	Size        int64
+}
+
+// Plan lists the files of a replication; it is written by a dry run so
+// that it can be reviewed and executed later
+type Plan struct {
+	Created  time.Time
+	Prefix   string
+	Template string       // layout of the destinations below Prefix
+	Mode     transferMode // copy, move or link the files
+	Actions  []Action
+}
+
+// buildPlan selects the media files to replicate from the database and
+// renders their destination below prefix with tmpl; the date of a file is
+// the first known date in order. Companions of the files recorded by the
+// scan follow them instead of getting their own destination.
+func buildPlan(db *sql.DB, prefix string, order fastdu.DateOrder, tmpl *fastdu.Template) (*Plan, error) {
+	companions, err := fdb.ReadCompanions(db)
+	if err != nil {
+		log.Printf("companions: %v; scan again to group sidecars with their files", err)
+	}
+
+	// files with identical content are copied once; getOriginalIfExists picks
+	// the copy to use from the duplicates of the group
+	query := `select %s, exif_create_date, media_created from media where (size > 20000 and mime_type = 'image' or mime_type = 'video')
+	and (group_id is null or path = (select min(path) from media m where m.group_id = media.group_id));`
+	query = fmt.Sprintf(query, fdb.MediaDBCols)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s got error %v", query, err)
+	}
+	defer rows.Close()
+
+	plan := &Plan{Created: time.Now(), Prefix: prefix, Template: tmpl.String()}
+	var values []map[string]any // template values of the actions
+	for rows.Next() {
+		var name, mime_type,
+			mime_subtype, mime_value, extension,
+			suffix_common_path, max_common_path, filepath, exif_json string
+		var size int64
+		var datetime time.Time
+		var exif_datetime_original, exif_create_date, media_created sql.NullTime
+		var count, file_size_mismatch int
+		err := rows.Scan(&name, &size, &datetime, &exif_datetime_original,
+			&mime_type, &mime_subtype, &mime_value, &extension, &count,
+			&file_size_mismatch, &suffix_common_path, &max_common_path, &filepath, &exif_json,
+			&exif_create_date, &media_created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		var dups []fastdu.Duplicate
+		if err := json.Unmarshal([]byte(filepath), &dups); err != nil || len(dups) == 0 {
+			fmt.Fprintf(os.Stderr, "skipping %s: invalid duplicates %q: %v\n", name, filepath, err)
+			continue
+		}
+
+		dates := fastdu.MediaDates{
+			Name:             name,
+			DateTimeOriginal: exif_datetime_original.Time,
+			CreateDate:       exif_create_date.Time,
+			MediaCreated:     media_created.Time,
+			Modtime:          datetime,
+		}
+		date, source := dates.CaptureDate(order)
+		reason := fmt.Sprintf("%s date %s", source, date.Format(time.DateOnly))
+		if len(dups) > 1 {
+			reason += fmt.Sprintf(", original of %d copies", len(dups))
+		}
+		plan.Actions = append(plan.Actions, Action{
+			Source:     getOriginalIfExists(dups),
+			Reason:     reason,
+			Date:       date,
+			DateSource: source,
+			Size:       size,
+		})
+		values = append(values, templateValues(name, extension, mime_type, mime_subtype, exif_json, date, source))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	values = plan.attachCompanions(companions, values)
+	plan.render(prefix, tmpl, values)
+	plan.companionDestinations()
+	sort.Slice(plan.Actions, func(i, j int) bool { return plan.Actions[i].Destination < plan.Actions[j].Destination })
+	plan.checkConflicts()
+	return plan, nil
+}
+
+// exifFields are the exif tags available to templates
+type exifFields struct {
+	Make, Model, LensModel, Artist string
+}
+
+// templateValues returns the template fields of a media file
+func templateValues(name, ext, mimeType, mimeSubtype, exifJSON string, date time.Time, source fastdu.DateSource) map[string]any {
+	var tags exifFields
+	_ = json.Unmarshal([]byte(exifJSON), &tags)
+	return map[string]any{
+		"year": date.Year(), "month": int(date.Month()), "day": date.Day(),
+		"hour": date.Hour(), "minute": date.Minute(), "second": date.Second(),
+		"date": date.Format("20060102"), "time": date.Format("150405"),
+		"file": name, "name": strings.TrimSuffix(name, filepath.Ext(name)), "ext": ext,
+		"mime_type": mimeType, "mime_subtype": mimeSubtype,
+		"camera_make": tags.Make, "camera_model": tags.Model, "lens": tags.LensModel, "artist": tags.Artist,
+		"date_source": source.String(),
+	}
+}
+
+// attachCompanions adds the companions of the sources to their actions and
+// drops the actions of files that are companions of another action; it
+// returns the template values of the remaining actions
+func (p *Plan) attachCompanions(companions map[string][]fastdu.Companion, values []map[string]any) []map[string]any {
+	if len(companions) == 0 {
+		return values
+	}
+	follows := make(map[string]bool)
+	for i := range p.Actions {
+		a := &p.Actions[i]
+		src, err := filepath.Abs(a.Source)
+		if err != nil {
+			continue
+		}
+		for _, c := range companions[src] {
+			fInfo, err := os.Stat(c.Path)
+			if err != nil {
+				continue
+			}
+			a.Companions = append(a.Companions, Companion{Source: c.Path, Kind: c.Kind, Size: fInfo.Size()})
+			follows[c.Path] = true
+		}
+	}
+
+	actions, vals := p.Actions[:0], values[:0]
+	for i, a := range p.Actions {
+		if src, err := filepath.Abs(a.Source); err == nil && follows[src] {
+			continue
+		}
+		actions, vals = append(actions, a), append(vals, values[i])
+	}
+	p.Actions = actions
+	return vals
+}
+
+// companionDestinations sets the planned destinations of the companions
+func (p *Plan) companionDestinations() {
+	for i := range p.Actions {
+		a := &p.Actions[i]
+		for j := range a.Companions {
+			a.Companions[j].Destination = fastdu.CompanionPath(a.Companions[j].Source, a.Source, a.Destination)
+		}
+	}
+}
+
+// render sets the destinations of the actions. Files that would get the same
+// path are numbered by date with the seq field, if the template has one
+func (p *Plan) render(prefix string, tmpl *fastdu.Template, values []map[string]any) {
+	if tmpl.Uses("seq") {
+		order := make([]int, len(p.Actions))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := p.Actions[order[i]], p.Actions[order[j]]
+			if !a.Date.Equal(b.Date) {
+				return a.Date.Before(b.Date)
+			}
+			return a.Source < b.Source
+		})
+		seq := make(map[string]int)
+		for _, i := range order {
+			values[i]["seq"] = "\x00"
+			key := tmpl.Execute(values[i])
+			seq[key]++
+			values[i]["seq"] = seq[key]
+		}
+	}
+	for i := range p.Actions {
+		p.Actions[i].Destination = filepath.Join(prefix, filepath.FromSlash(tmpl.Execute(values[i])))
+	}
+}
+
+// checkConflicts sets the conflict status of every action
+func (p *Plan) checkConflicts() {
+	seen := make(map[string]bool)
+	for i := range p.Actions {
+		a := &p.Actions[i]
+		a.Conflict = conflictNone
+		if seen[a.Destination] {
+			a.Conflict = conflictDuplicate
+			continue
+		}
+		seen[a.Destination] = true
+		if fInfo, err := os.Stat(a.Destination); err == nil {
+			a.Conflict = conflictExists
+			if fInfo.Size() != a.Size {
+				a.Conflict = conflictDiffers
+			}
+		}
+	}
+}
+
+// summary returns the number of actions and bytes, and the actions per
+// conflict status and date source
+func (p *Plan) summary() string {
+	files := make(map[string]int)
+	sources := make(map[fastdu.DateSource]int)
+	var bytes int64
+	var companions int
+	for _, a := range p.Actions {
+		files[a.Conflict]++
+		sources[a.DateSource]++
+		bytes += a.Size
+		for _, c := range a.Companions {
+			companions++
+			bytes += c.Size
+		}
+	}
+	var dates []string
+	for _, src := range fastdu.DefaultDateOrder {
+		if sources[src] > 0 {
+			dates = append(dates, fmt.Sprintf("%d %s", sources[src], src))
+		}
+	}
+	return fmt.Sprintf("%s %d files and %d companions, %s; dates: %s; conflicts: %d exist, %d differ, %d duplicate destinations",
+		p.Mode, len(p.Actions), companions, fastdu.FormatSize(bytes), strings.Join(dates, ", "),
+		files[conflictExists], files[conflictDiffers], files[conflictDuplicate])
+}
+
+func writePlan(file string, plan *Plan) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if file == "-" {
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+func readPlan(file string) (*Plan, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &plan, nil
+}
+
+// statusDone counts the jobs that were done by a previous run
+const statusDone = "done before"
+
+// result counts the outcomes of the copies of a plan
+type result struct {
+	mu       sync.Mutex
+	statuses map[string]int
+	errs     []error
+}
+
+func (r *result) add(status string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errs = append(r.errs, err)
+		return
+	}
+	r.statuses[status]++
+}
+
+func (r *result) String() string {
+	return fmt.Sprintf("%d copied, %d renamed, %d replaced, %d identical, %d kept existing, %d done by a previous run, %d errors",
+		r.statuses[statusCopied], r.statuses[statusRenamed], r.statuses[statusReplaced],
+		r.statuses[statusIdentical], r.statuses[statusKept], r.statuses[statusDone], len(r.errs))
+}
+
+// execute copies the files of the plan; collisions are resolved by policy,
+// and failed copies are reported and don't stop the others. Companions follow
+// the final destination of their file with the same name, and are left alone
+// if the file failed or an existing different file was kept. The state of
+// every copy is recorded in the journal, and copies that a previous run
+// finished are skipped as long as they were done in the same mode and their
+// destination exists. When ctx is cancelled, the copies in progress are
+// finished and the others are left for the next run.
+func (p *Plan) execute(ctx context.Context, numWorkers int, policy collisionPolicy, journal *fdb.Journal) *result {
+	res := &result{statuses: make(map[string]int)}
+	var jobs []fdb.Job
+	for _, a := range p.Actions {
+		jobs = append(jobs, fdb.Job{Source: a.Source, Destination: a.Destination, Mode: p.Mode.String(), Size: a.Size})
+		for _, c := range a.Companions {
+			jobs = append(jobs, fdb.Job{Source: c.Source, Destination: c.Destination, Mode: p.Mode.String(), Size: c.Size})
+		}
+	}
+	if err := journal.Add(jobs); err != nil {
+		res.add("", fmt.Errorf("journal: %w", err))
+		return res
+	}
+	finished, err := journal.Jobs(fdb.JobDone)
+	if err != nil {
+		res.add("", fmt.Errorf("journal: %w", err))
+		return res
+	}
+	done := make(doneJobs, len(finished))
+	for _, job := range finished {
+		if job.Mode != p.Mode.String() {
+			continue
+		}
+		done[[2]string{job.Source, job.Destination}] = job
+	}
+
+	actions := make(chan Action)
+	go func() {
+		defer close(actions)
+		for _, a := range p.Actions {
+			if done.all(a) {
+				res.add(statusDone, nil)
+				for range a.Companions {
+					res.add(statusDone, nil)
+				}
+				continue
+			}
+			// select picks any ready case, so a cancelled run could start more
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case actions <- a:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for a := range actions {
+				dst, status, ok := done.final(a.Source, a.Destination)
+				if ok {
+					res.add(statusDone, nil)
+				} else {
+					var err error
+					dst, status, err = p.transfer(a.Source, a.Destination, a.Destination, a.Size, policy, journal)
+					res.add(status, err)
+					if err != nil {
+						continue
+					}
+				}
+				if status == statusKept {
+					continue
+				}
+				for _, c := range a.Companions {
+					if _, _, ok := done.final(c.Source, c.Destination); ok {
+						res.add(statusDone, nil)
+						continue
+					}
+					cdst := fastdu.CompanionPath(c.Source, a.Source, dst)
+					_, status, err := p.transfer(c.Source, c.Destination, cdst, c.Size, companionPolicy(policy), journal)
+					res.add(status, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+// companionPolicy returns the collision policy of companions: they are only
+// found next to their file under its exact name, so a companion is never
+// renamed; a different existing file makes it fail instead
+func companionPolicy(policy collisionPolicy) collisionPolicy {
+	if policy == collisionRename {
+		return collisionFail
+	}
+	return policy
+}
+
+// doneJobs are the jobs that a previous run finished in the mode of the plan
+// by source and planned destination
+type doneJobs map[[2]string]fdb.Job
+
+// final returns the destination and outcome of a finished job whose
+// destination still exists
+func (d doneJobs) final(src, dst string) (string, string, bool) {
+	job, ok := d[[2]string{src, dst}]
+	if !ok {
+		return "", "", false
+	}
+	if _, err := os.Lstat(job.Final); err != nil {
+		return "", "", false
+	}
+	return job.Final, job.Result, true
+}
+
+// all reports whether the action and its companions are finished
+func (d doneJobs) all(a Action) bool {
+	if _, _, ok := d.final(a.Source, a.Destination); !ok {
+		return false
+	}
+	for _, c := range a.Companions {
+		if _, _, ok := d.final(c.Source, c.Destination); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// transfer transfers src to dst and records the job of src and its planned
+// destination in the journal; companions are planned next to the planned
+// destination of their file but follow its final destination
+func (p *Plan) transfer(src, planned, dst string, size int64, policy collisionPolicy, journal *fdb.Journal) (string, string, error) {
+	job := fdb.Job{Source: src, Destination: planned, Mode: p.Mode.String(), Size: size, State: fdb.JobCopying}
+	if err := journal.Update(job); err != nil {
+		log.Printf("journal: %v", err)
+	}
+
+	final, status := dst, ""
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err == nil {
+		final, status, err = transferFile(src, dst, policy, p.Mode)
+	}
+	if err != nil {
+		job.State, job.Error = fdb.JobFailed, err.Error()
+		err = fmt.Errorf("%s: %w", src, err)
+	} else {
+		job.State, job.Result, job.Final = fdb.JobDone, status, final
+		fmt.Printf("%s->%s (%s)\n", src, final, status)
+	}
+	if err := journal.Update(job); err != nil {
+		log.Printf("journal: %v", err)
+	}
+	return final, status, err
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fdb "github.com/ajoyka/fdu/db"
+	"github.com/ajoyka/fdu/fastdu"
+	"github.com/h2non/filetype/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func openJournal(t *testing.T) *fdb.Journal {
+	t.Helper()
+	db, err := fdb.Open(fdb.PathOrDSN(filepath.Join(t.TempDir(), "media.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	journal, err := fdb.OpenJournal(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journal
+}
+
+// openMediaDB writes the media rows of meta to a new media database, and
+// opens it for queries
+func openMediaDB(t *testing.T, meta ...*fastdu.Meta) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "media.db")
+	d, err := fdb.New(fdb.Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]*fastdu.Meta)
+	for _, m := range meta {
+		m.Dups = []fastdu.Duplicate{{Name: m.Path, Size: m.Size}}
+		rows[m.Path] = m
+	}
+	d.WriteMeta(rows)
+	d.Close()
+
+	db, err := fdb.Open(fdb.Options{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// photo returns the media row of a photo taken at date
+func photo(path string, size int64, date time.Time) *fastdu.Meta {
+	return &fastdu.Meta{Name: filepath.Base(path), Path: path, Size: size, Modtime: date,
+		Type: types.NewType("jpg", "image/jpeg"), DateTimeOriginal: date}
+}
+
+// TestBuildPlan plans the photos of a media database into a date tree that
+// already has some of the destinations
+func TestBuildPlan(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	date := time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC)
+	db := openMediaDB(t,
+		photo(filepath.Join(src, "a.jpg"), 30000, date),
+		photo(filepath.Join(src, "b.jpg"), 30000, date),
+		photo(filepath.Join(src, "c.jpg"), 30000, date),
+		photo(filepath.Join(src, "x", "d.jpg"), 30000, date),
+		photo(filepath.Join(src, "y", "d.jpg"), 30000, date),
+		photo(filepath.Join(src, "thumb.jpg"), 100, date), // too small to be a photo
+	)
+	day := filepath.Join(dst, "2019", "07", "14")
+	writeFile(t, filepath.Join(day, "b.jpg"), strings.Repeat("b", 30000))
+	writeFile(t, filepath.Join(day, "c.jpg"), "smaller c")
+
+	tmpl, err := fastdu.ParseTemplate(fastdu.DefaultTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := buildPlan(db, dst, fastdu.DefaultDateOrder, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conflicts := make(map[string][]string) // destination -> conflicts
+	for _, a := range plan.Actions {
+		assert.True(t, date.Equal(a.Date))
+		assert.Equal(t, fastdu.DateExif, a.DateSource)
+		conflicts[a.Destination] = append(conflicts[a.Destination], a.Conflict)
+	}
+	assert.Equal(t, map[string][]string{
+		filepath.Join(day, "a.jpg"): {conflictNone},
+		filepath.Join(day, "b.jpg"): {conflictExists},
+		filepath.Join(day, "c.jpg"): {conflictDiffers},
+		filepath.Join(day, "d.jpg"): {conflictNone, conflictDuplicate},
+	}, conflicts)
+	assert.Equal(t, "copy 5 files and 0 companions, "+fastdu.FormatSize(5*30000)+
+		"; dates: 5 exif; conflicts: 1 exist, 1 differ, 1 duplicate destinations", plan.summary())
+}
+
+// TestRenderSeq numbers the files that render to the same path by capture
+// date; the numbers don't skip existing files, whose collisions are left to
+// the collision policy like those of templates without seq
+func TestRenderSeq(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2019, 7, 14, 0, 0, 0, 0, time.UTC)
+	late := filepath.Join(dir, "src", "late", "IMG.jpg")
+	early := filepath.Join(dir, "src", "early", "IMG.jpg")
+	next := filepath.Join(dir, "src", "next", "IMG.jpg")
+	newPlan := func() (*Plan, []map[string]any) {
+		plan := &Plan{Mode: modeCopy}
+		var values []map[string]any
+		for _, f := range []struct {
+			src  string
+			date time.Time
+		}{{late, day.Add(12 * time.Hour)}, {early, day.Add(9 * time.Hour)}, {next, day.Add(33 * time.Hour)}} {
+			writeFile(t, f.src, "photo "+filepath.Base(filepath.Dir(f.src)))
+			plan.Actions = append(plan.Actions, Action{Source: f.src, Date: f.date, DateSource: fastdu.DateExif, Size: 11})
+			values = append(values, templateValues("IMG.jpg", "jpg", "image", "jpeg", "", f.date, fastdu.DateExif))
+		}
+		return plan, values
+	}
+	destinations := func(plan *Plan) map[string]string {
+		res := make(map[string]string)
+		for _, a := range plan.Actions {
+			res[a.Source] = a.Destination
+		}
+		return res
+	}
+
+	t.Run("seq", func(t *testing.T) {
+		plan, values := newPlan()
+		dst := t.TempDir()
+		tmpl, err := fastdu.ParseTemplate("{date}_{seq}.{ext}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.render(dst, tmpl, values)
+		assert.Equal(t, map[string]string{
+			early: filepath.Join(dst, "20190714_1.jpg"),
+			late:  filepath.Join(dst, "20190714_2.jpg"),
+			next:  filepath.Join(dst, "20190715_1.jpg"),
+		}, destinations(plan))
+
+		writeFile(t, filepath.Join(dst, "20190714_1.jpg"), "photo of an earlier run")
+		plan.checkConflicts()
+		assert.Equal(t, conflictDiffers, plan.Actions[1].Conflict)
+		assert.Equal(t, conflictNone, plan.Actions[0].Conflict)
+	})
+
+	t.Run("no seq", func(t *testing.T) {
+		plan, values := newPlan()
+		dst := t.TempDir()
+		tmpl, err := fastdu.ParseTemplate("{date}.{ext}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.render(dst, tmpl, values)
+		assert.Equal(t, plan.Actions[0].Destination, plan.Actions[1].Destination)
+		plan.checkConflicts()
+		assert.ElementsMatch(t, []string{conflictNone, conflictDuplicate},
+			[]string{plan.Actions[0].Conflict, plan.Actions[1].Conflict})
+
+		res := plan.execute(context.Background(), 1, collisionRename, openJournal(t))
+		assert.Empty(t, res.errs)
+		assert.Equal(t, 2, res.statuses[statusCopied])
+		assert.Equal(t, 1, res.statuses[statusRenamed])
+		assert.ElementsMatch(t, []string{"photo early", "photo late"},
+			[]string{readFile(t, filepath.Join(dst, "20190714.jpg")), readFile(t, filepath.Join(dst, "20190714_1.jpg"))})
+	})
+}
+
+func TestWritePlan(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plan.json")
+	plan := &Plan{Prefix: "/dst", Template: fastdu.DefaultTemplate, Mode: modeHardlink, Actions: []Action{
+		{Source: "/src/a.jpg", Destination: "/dst/2019/07/14/a.jpg", Reason: "exif date 2019-07-14",
+			Date: time.Date(2019, 7, 14, 10, 30, 0, 0, time.UTC), DateSource: fastdu.DateExif, Size: 5, Conflict: conflictNone,
+			Companions: []Companion{{Source: "/src/a.xmp", Destination: "/dst/2019/07/14/a.xmp", Kind: fastdu.CompanionSidecar, Size: 3}}},
+		{Source: "/src/b.jpg", Destination: "/dst/2019/07/14/b.jpg", Reason: "mtime date 2019-07-14",
+			Date: time.Date(2019, 7, 14, 0, 0, 0, 0, time.UTC), DateSource: fastdu.DateModtime, Size: 7, Conflict: conflictExists},
+	}}
+	assert.NoError(t, writePlan(file, plan))
+
+	got, err := readPlan(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, plan.Prefix, got.Prefix)
+		assert.Equal(t, plan.Template, got.Template)
+		assert.Equal(t, plan.Mode, got.Mode)
+		assert.Equal(t, plan.Actions, got.Actions)
+	}
+}
+
+// TestExecuteResume reruns a plan: finished copies are skipped, and copies
+// that were interrupted or whose destination is gone are done again
+func TestExecuteResume(t *testing.T) {
+	dir := t.TempDir()
+	plan := &Plan{Mode: modeCopy}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		src := filepath.Join(dir, "src", name)
+		writeFile(t, src, "photo "+name)
+		plan.Actions = append(plan.Actions, Action{Source: src, Destination: filepath.Join(dir, "dst", "2019", name), Size: 11})
+	}
+	journal := openJournal(t)
+
+	res := plan.execute(context.Background(), 2, collisionFail, journal)
+	assert.Empty(t, res.errs)
+	assert.Equal(t, 3, res.statuses[statusCopied])
+
+	res = plan.execute(context.Background(), 2, collisionFail, journal)
+	assert.Empty(t, res.errs)
+	assert.Equal(t, 3, res.statuses[statusDone])
+
+	// a is interrupted while copying and b is removed from the destination
+	a, b := plan.Actions[0], plan.Actions[1]
+	assert.NoError(t, journal.Update(fdb.Job{Source: a.Source, Destination: a.Destination, Mode: "copy", State: fdb.JobCopying}))
+	assert.NoError(t, os.Remove(a.Destination))
+	writeFile(t, tempName(a.Source, a.Destination), "pho")
+	assert.NoError(t, os.Remove(b.Destination))
+
+	res = plan.execute(context.Background(), 2, collisionFail, journal)
+	assert.Empty(t, res.errs)
+	assert.Equal(t, 2, res.statuses[statusCopied])
+	assert.Equal(t, 1, res.statuses[statusDone])
+	assert.Equal(t, "photo a.jpg", readFile(t, a.Destination))
+	assert.Equal(t, "photo b.jpg", readFile(t, b.Destination))
+	_, err := os.Lstat(tempName(a.Source, a.Destination))
+	assert.True(t, os.IsNotExist(err))
+
+	// links aren't copies: a rerun in another mode isn't done before
+	plan.Mode = modeSymlink
+	res = plan.execute(context.Background(), 2, collisionRename, journal)
+	assert.Empty(t, res.errs)
+	assert.Zero(t, res.statuses[statusDone])
+}
+
+// TestExecuteCancelled leaves the copies that didn't start for the next run
+func TestExecuteCancelled(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "a.jpg")
+	writeFile(t, src, "photo")
+	plan := &Plan{Mode: modeCopy, Actions: []Action{{Source: src, Destination: filepath.Join(dir, "dst", "a.jpg"), Size: 5}}}
+	journal := openJournal(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := plan.execute(ctx, 1, collisionFail, journal)
+	assert.Empty(t, res.errs)
+	assert.Zero(t, res.statuses[statusCopied])
+
+	res = plan.execute(context.Background(), 1, collisionFail, journal)
+	assert.Equal(t, 1, res.statuses[statusCopied])
+	assert.Equal(t, "photo", readFile(t, plan.Actions[0].Destination))
+}
+
+// TestExecuteCompanions places companions next to the final destination of
+// their file under the same name, also when the file was renamed
+func TestExecuteCompanions(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_1.jpg")
+	writeFile(t, src, "photo")
+	writeFile(t, filepath.Join(dir, "src", "IMG_1.xmp"), "edits")
+	writeFile(t, filepath.Join(dir, "src", "VID_7.MOV"), "motion")
+	dst := filepath.Join(dir, "dst", "IMG_1.jpg")
+	plan := &Plan{Mode: modeCopy, Actions: []Action{{Source: src, Destination: dst, Size: 5, Companions: []Companion{
+		{Source: filepath.Join(dir, "src", "IMG_1.xmp"), Kind: fastdu.CompanionSidecar, Size: 5},
+		{Source: filepath.Join(dir, "src", "VID_7.MOV"), Kind: fastdu.CompanionVideo, Size: 6},
+	}}}}
+	plan.companionDestinations()
+
+	// another photo has the destination, and the sidecar name of the renamed
+	// copy has different edits
+	writeFile(t, dst, "other photo")
+	writeFile(t, filepath.Join(dir, "dst", "IMG_1_1.xmp"), "other edits")
+
+	res := plan.execute(context.Background(), 1, collisionRename, openJournal(t))
+	assert.Equal(t, 1, res.statuses[statusRenamed])
+	assert.Equal(t, 1, res.statuses[statusCopied])
+	assert.Len(t, res.errs, 1) // the sidecar isn't renamed apart from its photo
+	assert.Equal(t, "photo", readFile(t, filepath.Join(dir, "dst", "IMG_1_1.jpg")))
+	assert.Equal(t, "motion", readFile(t, filepath.Join(dir, "dst", "IMG_1_1.MOV")))
+	assert.Equal(t, "other edits", readFile(t, filepath.Join(dir, "dst", "IMG_1_1.xmp")))
+	_, err := os.Lstat(filepath.Join(dir, "dst", "IMG_1_1_1.xmp"))
+	assert.True(t, os.IsNotExist(err))
+}